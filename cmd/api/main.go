@@ -3,15 +3,16 @@ package main
 import (
 	"context"
 	"eatsome/internal/api"
+	"eatsome/internal/blob"
 	"eatsome/internal/db"
 	"eatsome/internal/recognition"
 	"eatsome/internal/s3"
+	"eatsome/internal/scheduler"
+	"eatsome/internal/telegram"
 	"eatsome/internal/terrors"
 	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
-	"github.com/golang-jwt/jwt/v5"
-	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"gopkg.in/yaml.v3"
@@ -22,22 +23,42 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 )
 
+// localBlobsPath is where the local blob store is served.
+const localBlobsPath = "/blobs"
+
 type Config struct {
 	Host             string `yaml:"host"`
 	Port             int    `yaml:"port"`
 	DBPath           string `yaml:"db_path"`
 	TelegramBotToken string `yaml:"telegram_bot_token"`
-	JWTSecret        string `yaml:"jwt_secret"`
-	AWS              struct {
+	Telegram         struct {
+		APIURL        string `yaml:"api_url"`
+		WebhookSecret string `yaml:"webhook_secret"`
+		WebAppURL     string `yaml:"webapp_url"`
+	} `yaml:"telegram"`
+	JWTSecret string `yaml:"jwt_secret"`
+	AWS       struct {
 		AccessKeyID     string `yaml:"access_key_id"`
 		SecretAccessKey string `yaml:"secret_access_key"`
 		Endpoint        string `yaml:"endpoint"`
 		Bucket          string `yaml:"bucket"`
 	} `yaml:"aws"`
-	OpenAIKey string `yaml:"openai_key"`
-	AssetsURL string `yaml:"assets_url"`
+	// Blobs selects where photos and exports are kept: the S3 bucket above
+	// or, for local development, a directory served by the API under
+	// /blobs. With the local backend assets_url must point there. Private
+	// is set when the bucket has no public access, so photos are only
+	// served through signed URLs.
+	Blobs struct {
+		Backend string `yaml:"backend" validate:"omitempty,oneof=s3 local"`
+		Dir     string `yaml:"dir" validate:"required_if=Backend local"`
+		Private bool   `yaml:"private"`
+	} `yaml:"blobs"`
+	OpenAIKey    string `yaml:"openai_key"`
+	AssetsURL    string `yaml:"assets_url"`
+	ReminderHour int    `yaml:"reminder_hour"`
 }
 
 func ReadConfig(filePath string) (*Config, error) {
@@ -134,34 +155,6 @@ func (cv *customValidator) Validate(i interface{}) error {
 	return nil
 }
 
-func getAuthConfig(secret string) echojwt.Config {
-	return echojwt.Config{
-		NewClaimsFunc: func(_ echo.Context) jwt.Claims {
-			return new(api.JWTClaims)
-		},
-		SigningKey:             []byte(secret),
-		ContinueOnIgnoredError: true,
-		ErrorHandler: func(c echo.Context, err error) error {
-			var extErr *echojwt.TokenExtractionError
-			if !errors.As(err, &extErr) {
-				return echo.NewHTTPError(http.StatusUnauthorized, "auth is invalid")
-			}
-
-			claims := &api.JWTClaims{
-				RegisteredClaims: jwt.RegisteredClaims{
-					ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour * 30)),
-				},
-			}
-
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-			c.Set("user", token)
-
-			return nil
-		},
-	}
-}
-
 func gracefulShutdown(e *echo.Echo, done chan<- bool) {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -208,7 +201,7 @@ func main() {
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
-		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
+		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
 		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
 	}))
 
@@ -220,42 +213,142 @@ func main() {
 
 	e.Validator = &customValidator{validator: validator.New()}
 
-	s3Client, err := s3.NewS3Client(
-		cfg.AWS.AccessKeyID, cfg.AWS.SecretAccessKey, cfg.AWS.Endpoint, cfg.AWS.Bucket)
+	var blobs blob.BlobStore
 
-	if err != nil {
-		log.Fatalf("Failed to initialize AWS S3 client: %v\n", err)
+	switch cfg.Blobs.Backend {
+	case "local":
+		local, err := blob.NewLocal(cfg.Blobs.Dir, cfg.AssetsURL, cfg.JWTSecret)
+		if err != nil {
+			log.Fatalf("Failed to initialize local blob store: %v\n", err)
+		}
+
+		local.Private = cfg.Blobs.Private
+
+		e.Any(localBlobsPath+"/*", echo.WrapHandler(http.StripPrefix(localBlobsPath, local)))
+		blobs = local
+	default:
+		s3Client, err := s3.NewS3Client(
+			cfg.AWS.AccessKeyID, cfg.AWS.SecretAccessKey, cfg.AWS.Endpoint, cfg.AWS.Bucket)
+
+		if err != nil {
+			log.Fatalf("Failed to initialize AWS S3 client: %v\n", err)
+		}
+
+		blobs = s3Client
 	}
 
 	apiCfg := api.Config{
-		BotToken:  cfg.TelegramBotToken,
-		JWTSecret: cfg.JWTSecret,
-		AssetsURL: cfg.AssetsURL,
+		BotToken:      cfg.TelegramBotToken,
+		WebhookSecret: cfg.Telegram.WebhookSecret,
+		WebAppURL:     cfg.Telegram.WebAppURL,
+		JWTSecret:     cfg.JWTSecret,
+		AssetsURL:     cfg.AssetsURL,
+		PrivatePhotos: cfg.Blobs.Private,
+		ReminderHour:  cfg.ReminderHour,
 	}
 
 	recognizer := recognition.New(cfg.OpenAIKey)
 
-	a := api.New(storage, apiCfg, s3Client, recognizer)
+	bot := telegram.New(cfg.TelegramBotToken, cfg.Telegram.APIURL)
+
+	a := api.New(storage, apiCfg, blobs, recognizer, bot)
 
 	tmConfig := middleware.TimeoutConfig{
 		Timeout: 20 * time.Second,
+		// The timeout handler buffers the whole response, which would defeat
+		// streaming exports.
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == "/api/meals/export"
+		},
 	}
 
 	e.Use(middleware.TimeoutWithConfig(tmConfig))
 
 	e.POST("/auth/telegram", a.AuthTelegram)
+	e.POST("/telegram/webhook", a.TelegramWebhook)
 
 	// Routes
 	g := e.Group("/api")
 
-	authCfg := getAuthConfig(cfg.JWTSecret)
-
-	g.Use(echojwt.WithConfig(authCfg))
+	g.Use(api.AuthMiddleware(cfg.JWTSecret))
+	g.Use(a.UserMiddleware)
 
 	g.GET("/meals", a.GetMeals)
+	g.GET("/meals/export", a.ExportMeals)
+	g.POST("/meals/imports", a.ImportMeals)
+	g.GET("/meals/imports/:id", a.GetMealImport)
 	g.POST("/meals", a.CreateMeal)
-	g.PUT("/meals/:id", a.UpdateMeal)
+	g.GET("/meals/:id", a.GetMeal)
+	g.PATCH("/meals/:id", a.UpdateMeal)
+	g.DELETE("/meals/:id", a.DeleteMeal)
+	g.GET("/meals/:id/history", a.GetMealHistory)
+	g.POST("/meals/:id/restore", a.RestoreMeal)
+	g.GET("/meals/:id/comments", a.ListComments)
+	g.POST("/meals/:id/comments", a.AddComment)
+	g.POST("/meals/:id/reports", a.ReportMeal)
+	g.POST("/meals/:id/appeal", a.AppealMeal)
+	g.POST("/comments/:id/reports", a.ReportComment)
+	g.POST("/meals/:id/reactions", a.ToggleReaction)
+	g.DELETE("/meals/:id/reactions", a.DeleteReaction)
+	g.POST("/meals/:id/ingredients", a.AddIngredient)
+	g.PATCH("/meals/:id/ingredients/:index", a.UpdateIngredient)
+	g.DELETE("/meals/:id/ingredients/:index", a.DeleteIngredient)
 	g.POST("/presigned-url", a.GetPresignedURL)
+	g.POST("/photos/confirm", a.ConfirmUpload)
+	g.PUT("/user/settings", a.UpdateUserSettings)
+	g.GET("/users/:id", a.GetProfile)
+	g.GET("/users/:id/meals", a.GetUserMeals)
+	g.GET("/leaderboards/:board", a.GetLeaderboard)
+	g.POST("/user/deletion", a.RequestAccountDeletion)
+	g.DELETE("/user", a.DeleteAccount)
+	g.POST("/user/exports", a.CreateDataExport)
+	g.GET("/user/exports/:id", a.GetDataExport)
+	g.GET("/goals", a.ListGoals)
+	g.PUT("/goals", a.SetGoals)
+	g.GET("/goals/progress", a.GetGoalProgress)
+	g.GET("/body-metrics", a.ListBodyMetrics)
+	g.POST("/body-metrics", a.CreateBodyMetric)
+	g.GET("/body-metrics/trend", a.GetBodyMetricsTrend)
+	g.GET("/body-metrics/tdee", a.EstimateTDEE)
+	g.GET("/body-metrics/:id", a.GetBodyMetric)
+	g.PUT("/body-metrics/:id", a.UpdateBodyMetric)
+	g.DELETE("/body-metrics/:id", a.DeleteBodyMetric)
+	g.GET("/beverages", a.GetHydrationSummary)
+	g.POST("/beverages", a.AddBeverage)
+	g.DELETE("/beverages/:id", a.DeleteBeverage)
+
+	mod := g.Group("/moderation", api.RequireRole(db.RoleModerator, db.RoleAdmin))
+	mod.GET("/reports", a.ListModerationQueue)
+	mod.POST("/reports/:id/:action", a.ModerateReport)
+
+	admin := g.Group("/admin", api.RequireRole(db.RoleAdmin))
+	admin.GET("/users", a.SearchUsers)
+	admin.POST("/users/:id/ban", a.BanUser)
+	admin.DELETE("/users/:id/ban", a.UnbanUser)
+	admin.PUT("/users/:id/role", a.SetUserRole)
+	admin.POST("/users/:id/impersonate", a.ImpersonateUser)
+	admin.GET("/tags", a.ListTags)
+	admin.POST("/tags", a.CreateTag)
+	admin.PATCH("/tags/:id", a.RenameTag)
+	admin.DELETE("/tags/:id", a.DeleteTag)
+	admin.POST("/meals/:id/reanalyze", a.ReanalyzeMeal)
+	admin.GET("/recognition-failures", a.ListRecognitionFailures)
+	admin.GET("/audit-log", a.ListAuditLog)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sched := scheduler.New()
+	sched.Add("notifications", time.Minute, a.SendScheduledNotifications)
+	sched.Add("meal-purge", 5*time.Minute, a.PurgeDeletedMeals)
+	sched.Add("object-purge", 5*time.Minute, a.PurgeDeletedObjects)
+	sched.Add("data-exports", time.Minute, a.ProcessDataExports)
+	sched.Add("meal-imports", time.Minute, a.ProcessMealImports)
+	sched.Add("leaderboards", time.Hour, a.RefreshLeaderboards)
+	sched.Add("bot-updates", time.Hour, a.PurgeBotUpdates)
+	sched.Start(ctx)
+
+	a.StartBotWorkers(ctx)
 
 	done := make(chan bool, 1)
 
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// accountDeletionWindow is how long a deletion confirmation code is valid.
+const accountDeletionWindow = 10 * time.Minute
+
+// avatarPrefix holds avatars copied from Telegram. Other avatars are shared
+// placeholders and must not be deleted with an account.
+const avatarPrefix = "fb/users/"
+
+type AccountDeletionResponse struct {
+	ConfirmationCode string    `json:"confirmation_code"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+type DeleteAccountRequest struct {
+	ConfirmationCode string `json:"confirmation_code" validate:"required"`
+}
+
+// RequestAccountDeletion starts deleting the caller's account. Nothing is
+// deleted until the returned code is sent back to DeleteAccount.
+func (a *API) RequestAccountDeletion(c echo.Context) error {
+	uid := getUserID(c)
+
+	code, err := gonanoid.New(12)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot generate confirmation code")
+	}
+
+	expiresAt := time.Now().Add(accountDeletionWindow).UTC().Truncate(time.Second)
+
+	if err := a.storage.SetAccountDeletionCode(uid, code, expiresAt); err != nil {
+		return terrors.InternalServerError(err, "cannot request account deletion")
+	}
+
+	return c.JSON(http.StatusOK, AccountDeletionResponse{
+		ConfirmationCode: code,
+		ExpiresAt:        expiresAt,
+	})
+}
+
+// DeleteAccount permanently deletes the caller with all their data. Their
+// photos, exports and avatar are removed from the bucket by
+// PurgeDeletedObjects.
+func (a *API) DeleteAccount(c echo.Context) error {
+	uid := getUserID(c)
+
+	var req DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	objects := []db.ObjectDeletion{{Key: fmt.Sprintf("%d/", uid), IsPrefix: true}}
+
+	if user.AvatarURL != nil {
+		if key, ok := a.objectKey(*user.AvatarURL); ok && strings.HasPrefix(key, avatarPrefix) {
+			objects = append(objects, db.ObjectDeletion{Key: key})
+		}
+	}
+
+	err = a.storage.DeleteUserByID(uid, req.ConfirmationCode, objects)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.BadRequest(err, "invalid or expired confirmation code")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot delete account")
+	}
+
+	go func() {
+		if err := a.PurgeDeletedObjects(context.Background()); err != nil {
+			log.Printf("Failed to purge objects of user %d: %v", uid, err)
+		}
+	}()
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PurgeDeletedObjects removes queued objects from the bucket. Deletions that
+// fail stay queued and are retried on the next run. It is called by the
+// scheduler and right after an account is deleted.
+func (a *API) PurgeDeletedObjects(ctx context.Context) error {
+	objects, err := a.storage.ListObjectDeletions()
+	if err != nil {
+		return fmt.Errorf("failed to list object deletions: %w", err)
+	}
+
+	for _, o := range objects {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if o.IsPrefix {
+			err = a.blobs.DeletePrefix(ctx, o.Key)
+		} else {
+			err = a.blobs.DeleteFile(ctx, o.Key)
+		}
+
+		if err != nil {
+			log.Printf("Failed to delete %s, will retry: %v", o.Key, err)
+			continue
+		}
+
+		if err := a.storage.DeleteObjectDeletion(o.ID); err != nil {
+			log.Printf("Failed to dequeue deletion of %s: %v", o.Key, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// healthyRating is the health rating from which a meal counts as healthy.
+const healthyRating = 70
+
+// achievementStats is what achievement rules are evaluated against.
+type achievementStats struct {
+	Meals        int
+	HealthyMeals int
+	Streak       StreakResponse
+	// ProteinRun is the longest run of days on which the protein goal was
+	// met.
+	ProteinRun int
+}
+
+type achievementRule struct {
+	ID       string
+	Unlocked func(s achievementStats) bool
+}
+
+// achievementRules are checked in order whenever a user's meals change.
+// Unlocked achievements are never taken back.
+var achievementRules = []achievementRule{
+	{db.AchievementFirstMeal, func(s achievementStats) bool { return s.Meals >= 1 }},
+	{db.AchievementStreak7, func(s achievementStats) bool { return s.Streak.Longest >= 7 }},
+	{db.AchievementHealthyMeals, func(s achievementStats) bool { return s.HealthyMeals >= 30 }},
+	{db.AchievementProteinStreak, func(s achievementStats) bool { return s.ProteinRun >= 5 }},
+}
+
+// StreakResponse counts consecutive local days with at least one meal. The
+// current streak still counts until the end of the day after its last meal.
+type StreakResponse struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+type ProfileResponse struct {
+	User         UserResponse     `json:"user"`
+	Streak       StreakResponse   `json:"streak"`
+	Achievements []db.Achievement `json:"achievements"`
+}
+
+// nextDay reports whether date is the day after prev. Both are local dates.
+func nextDay(prev, date string) bool {
+	day, err := time.Parse(dateLayout, prev)
+	if err != nil {
+		return false
+	}
+
+	return day.AddDate(0, 0, 1).Format(dateLayout) == date
+}
+
+// mealStreak returns the streaks of days, which are in order, as of today.
+func mealStreak(days []db.DailyTotals, today time.Time) StreakResponse {
+	var streak StreakResponse
+	var run int
+	var last string
+
+	for _, d := range days {
+		if d.Meals == 0 {
+			continue
+		}
+
+		if last != "" && nextDay(last, d.Date) {
+			run++
+		} else {
+			run = 1
+		}
+
+		last = d.Date
+		streak.Longest = max(streak.Longest, run)
+	}
+
+	if last == today.Format(dateLayout) || last == today.AddDate(0, 0, -1).Format(dateLayout) {
+		streak.Current = run
+	}
+
+	return streak
+}
+
+// proteinRun returns the longest run of consecutive days, which are in
+// order, on which the protein goal in force was met.
+func proteinRun(days []db.DailyTotals, goals []db.Goal) int {
+	var longest, run int
+	var last string
+
+	for _, d := range days {
+		day, err := time.Parse(dateLayout, d.Date)
+		if err != nil {
+			continue
+		}
+
+		goal := goalForDate(goals, d.Date, day.Weekday())
+		if goal == nil || goal.Proteins <= 0 || d.Totals.Proteins < goal.Proteins {
+			continue
+		}
+
+		if last != "" && nextDay(last, d.Date) {
+			run++
+		} else {
+			run = 1
+		}
+
+		last = d.Date
+		longest = max(longest, run)
+	}
+
+	return longest
+}
+
+// dailyHistory returns every day the user logged something, in their
+// timezone, up to today.
+func (a *API) dailyHistory(user *db.User, today time.Time) ([]db.DailyTotals, error) {
+	return a.storage.ListDailyTotals(user.ID, time.Unix(0, 0), today.AddDate(0, 0, 1), userLocation(user))
+}
+
+func (a *API) achievementStats(user *db.User) (achievementStats, error) {
+	var stats achievementStats
+
+	counts, err := a.storage.GetMealCounts(user.ID, healthyRating)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count meals: %w", err)
+	}
+
+	today := startOfDay(time.Now().In(userLocation(user)))
+
+	days, err := a.dailyHistory(user, today)
+	if err != nil {
+		return stats, fmt.Errorf("failed to list days: %w", err)
+	}
+
+	goals, err := a.storage.ListGoals(user.ID)
+	if err != nil {
+		return stats, fmt.Errorf("failed to list goals: %w", err)
+	}
+
+	stats.Meals = counts.Meals
+	stats.HealthyMeals = counts.Healthy
+	stats.Streak = mealStreak(days, today)
+	stats.ProteinRun = proteinRun(days, goals)
+
+	return stats, nil
+}
+
+// checkAchievements unlocks the achievements the user has earned and tells
+// them about new ones if they have notifications enabled.
+func (a *API) checkAchievements(uid int64) {
+	user, err := a.storage.GetUserByID(uid)
+	if err != nil {
+		log.Printf("Failed to get user %d for achievements: %v", uid, err)
+		return
+	}
+
+	stats, err := a.achievementStats(user)
+	if err != nil {
+		log.Printf("Failed to check achievements of user %d: %v", uid, err)
+		return
+	}
+
+	for _, rule := range achievementRules {
+		if !rule.Unlocked(stats) {
+			continue
+		}
+
+		unlocked, err := a.storage.UnlockAchievement(uid, rule.ID)
+		if err != nil {
+			log.Printf("Failed to unlock %s for user %d: %v", rule.ID, uid, err)
+			continue
+		}
+
+		if unlocked {
+			a.notifyAchievement(user, rule.ID)
+		}
+	}
+}
+
+func (a *API) notifyAchievement(user *db.User, achievement string) {
+	if !user.NotificationsEnabled {
+		return
+	}
+
+	content := getBotContent(userLanguage(user))
+	text := fmt.Sprintf(content.AchievementUnlocked, content.Achievements[achievement])
+
+	if err := a.sendBotMessage(user.ChatID, text, content.OpenApp, a.cfg.WebAppURL); err != nil {
+		log.Printf("Failed to notify user %d about achievement %s: %v", user.ID, achievement, err)
+	}
+}
+
+// GetProfile returns a user with their logging streak and achievements.
+func (a *API) GetProfile(c echo.Context) error {
+	uid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return terrors.BadRequest(err, "invalid user id")
+	}
+
+	user, err := a.storage.GetUserByID(uid)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "user not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get user")
+	}
+
+	today := startOfDay(time.Now().In(userLocation(user)))
+
+	days, err := a.dailyHistory(user, today)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot get streak")
+	}
+
+	achievements, err := a.storage.ListAchievements(uid)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list achievements")
+	}
+
+	return c.JSON(http.StatusOK, ProfileResponse{
+		User: UserResponse{
+			ID:        user.ID,
+			Username:  user.Username,
+			AvatarURL: a.avatarReadURL(user.AvatarURL),
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+		},
+		Streak:       mealStreak(days, today),
+		Achievements: achievements,
+	})
+}
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"eatsome/internal/contract"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// impersonationTTL is how long a support token for acting as a user lasts.
+const impersonationTTL = time.Hour
+
+// adminListSize is how many rows admin lists return by default and at most.
+const adminListSize = 100
+
+// Actions in the audit log.
+const (
+	auditBan                 = "ban"
+	auditUnban               = "unban"
+	auditSetRole             = "set_role"
+	auditImpersonate         = "impersonate"
+	auditImpersonatedRequest = "impersonated_request"
+	auditCreateTag           = "create_tag"
+	auditRenameTag           = "rename_tag"
+	auditDeleteTag           = "delete_tag"
+	auditReanalyzeMeal       = "reanalyze_meal"
+	auditApproveReport       = "approve_report"
+	auditRemoveReport        = "remove_report"
+)
+
+type AdminUserResponse struct {
+	contract.UserResponse
+	BannedAt *time.Time `json:"banned_at"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+type ImpersonationResponse struct {
+	Token     string            `json:"token"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	User      AdminUserResponse `json:"user"`
+}
+
+type TagRequest struct {
+	Name string `json:"name" validate:"required,max=50"`
+}
+
+// audit records an action of the caller in the audit log. While
+// impersonating, the admin is the actor. Failing to record an action does
+// not fail it.
+func (a *API) audit(c echo.Context, action string, targetUserID, targetID *int64, details string) {
+	claims, _ := ClaimsFromContext(c.Request().Context())
+
+	entry := db.AuditLogEntry{
+		ActorID:      &claims.UID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		TargetID:     targetID,
+	}
+
+	if claims.ImpersonatorID != 0 {
+		entry.ActorID = &claims.ImpersonatorID
+		entry.ImpersonatedID = &claims.UID
+	}
+
+	if details != "" {
+		entry.Details = &details
+	}
+
+	if err := a.storage.AddAuditLogEntry(entry); err != nil {
+		log.Printf("Failed to audit %s by user %d: %v", action, *entry.ActorID, err)
+	}
+}
+
+func (a *API) adminUserResponse(user *db.User) AdminUserResponse {
+	return AdminUserResponse{UserResponse: a.userResponse(user), BannedAt: user.BannedAt}
+}
+
+// listLimit reads the "limit" query parameter, capped at adminListSize.
+func listLimit(c echo.Context) (int, error) {
+	limit := adminListSize
+
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return 0, terrors.BadRequest(fmt.Errorf("invalid limit %q", l), "invalid limit")
+		}
+
+		limit = min(n, adminListSize)
+	}
+
+	return limit, nil
+}
+
+// targetUser returns the user in the "id" path parameter, who must not be
+// the caller.
+func (a *API) targetUser(c echo.Context) (*db.User, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, terrors.BadRequest(err, "invalid user id")
+	}
+
+	if id == getUserID(c) {
+		return nil, terrors.BadRequest(errors.New("admin targets themselves"), "cannot do this to yourself")
+	}
+
+	return a.getUser(id)
+}
+
+// SearchUsers finds users by username, name, ID or chat ID given in "q".
+func (a *API) SearchUsers(c echo.Context) error {
+	query := strings.TrimSpace(c.QueryParam("q"))
+	if query == "" {
+		return terrors.BadRequest(errors.New("empty query"), "q is required")
+	}
+
+	limit, err := listLimit(c)
+	if err != nil {
+		return err
+	}
+
+	users, err := a.storage.SearchUsers(query, limit)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot search users")
+	}
+
+	resp := make([]AdminUserResponse, 0, len(users))
+	for i := range users {
+		resp = append(resp, a.adminUserResponse(&users[i]))
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) BanUser(c echo.Context) error {
+	return a.setUserBanned(c, true)
+}
+
+func (a *API) UnbanUser(c echo.Context) error {
+	return a.setUserBanned(c, false)
+}
+
+func (a *API) setUserBanned(c echo.Context, banned bool) error {
+	target, err := a.targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.storage.SetUserBanned(target.ID, banned)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "user not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot update user")
+	}
+
+	a.logChange(staffActor(c), db.ChangeUpdate, db.EntityUser, user.ID, &user.ID, userSnapshot(target), userSnapshot(user))
+
+	action := auditUnban
+	if banned {
+		action = auditBan
+	}
+
+	a.audit(c, action, &user.ID, nil, "")
+
+	return c.JSON(http.StatusOK, a.adminUserResponse(user))
+}
+
+// SetUserRole changes a user's role. Tokens issued for the old role stop
+// working.
+func (a *API) SetUserRole(c echo.Context) error {
+	target, err := a.targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req SetRoleRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	user, err := a.storage.SetUserRole(target.ID, req.Role)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "user not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot update user")
+	}
+
+	a.logChange(staffActor(c), db.ChangeUpdate, db.EntityUser, user.ID, &user.ID, userSnapshot(target), userSnapshot(user))
+
+	a.audit(c, auditSetRole, &user.ID, nil, target.Role+" -> "+user.Role)
+
+	return c.JSON(http.StatusOK, a.adminUserResponse(user))
+}
+
+// ImpersonateUser issues a short-lived token to act as a user for support.
+// Only regular users who are not banned can be impersonated, and every
+// request made with the token is audited.
+func (a *API) ImpersonateUser(c echo.Context) error {
+	target, err := a.targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	if target.Role != db.RoleUser || target.BannedAt != nil {
+		return terrors.Conflict(errors.New("user cannot be impersonated"), "only active regular users can be impersonated")
+	}
+
+	token, err := generateJWT(target, getUserID(c), impersonationTTL, a.cfg.JWTSecret)
+	if err != nil {
+		return terrors.InternalServerError(err, "jwt library error")
+	}
+
+	a.audit(c, auditImpersonate, &target.ID, nil, "")
+
+	return c.JSON(http.StatusOK, ImpersonationResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(impersonationTTL),
+		User:      a.adminUserResponse(target),
+	})
+}
+
+func (a *API) ListTags(c echo.Context) error {
+	tags, err := a.storage.ListTags()
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list tags")
+	}
+
+	if tags == nil {
+		tags = []db.Tag{}
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+// getTag looks a tag up in the vocabulary, which is small.
+func (a *API) getTag(id int64) (*db.Tag, error) {
+	tags, err := a.storage.ListTags()
+	if err != nil {
+		return nil, terrors.InternalServerError(err, "cannot list tags")
+	}
+
+	for i := range tags {
+		if tags[i].ID == id {
+			return &tags[i], nil
+		}
+	}
+
+	return nil, terrors.NotFound(db.ErrNotFound, "tag not found")
+}
+
+func (a *API) CreateTag(c echo.Context) error {
+	var req TagRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	tag, err := a.storage.CreateTag(strings.TrimSpace(req.Name))
+	if err != nil && errors.Is(err, db.ErrAlreadyExists) {
+		return terrors.Conflict(err, "tag already exists")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot create tag")
+	}
+
+	a.audit(c, auditCreateTag, nil, &tag.ID, tag.Name)
+	a.logChange(staffActor(c), db.ChangeCreate, db.EntityTag, tag.ID, nil, nil, tag)
+
+	return c.JSON(http.StatusCreated, tag)
+}
+
+func (a *API) RenameTag(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return terrors.BadRequest(err, "invalid tag id")
+	}
+
+	var req TagRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	before, err := a.getTag(id)
+	if err != nil {
+		return err
+	}
+
+	tag, err := a.storage.RenameTag(id, strings.TrimSpace(req.Name))
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "tag not found")
+	} else if err != nil && errors.Is(err, db.ErrAlreadyExists) {
+		return terrors.Conflict(err, "tag already exists")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot rename tag")
+	}
+
+	a.audit(c, auditRenameTag, nil, &tag.ID, tag.Name)
+	a.logChange(staffActor(c), db.ChangeUpdate, db.EntityTag, tag.ID, nil, before, tag)
+
+	return c.JSON(http.StatusOK, tag)
+}
+
+// DeleteTag removes a tag from the vocabulary and from every meal.
+func (a *API) DeleteTag(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return terrors.BadRequest(err, "invalid tag id")
+	}
+
+	before, err := a.getTag(id)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.DeleteTag(id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "tag not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot delete tag")
+	}
+
+	a.audit(c, auditDeleteTag, nil, &id, "")
+	a.logChange(staffActor(c), db.ChangeDelete, db.EntityTag, id, nil, before, nil)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ReanalyzeMeal runs recognition on a meal again, replacing its analysis.
+// Meals whose ingredients the owner corrected are refused with a conflict
+// unless "force" is true, which replaces the corrections too. The owner is
+// not notified. Failures show up in ListRecognitionFailures.
+func (a *API) ReanalyzeMeal(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return terrors.BadRequest(err, "invalid meal id")
+	}
+
+	force := false
+	if f := c.QueryParam("force"); f != "" {
+		force, err = strconv.ParseBool(f)
+		if err != nil {
+			return terrors.BadRequest(err, "invalid force")
+		}
+	}
+
+	meal, err := a.storage.GetMealByID(id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get meal")
+	}
+
+	if meal.IngredientsEditedAt != nil && !force {
+		return terrors.Conflict(errors.New("ingredients edited"), "meal ingredients were corrected by the owner, use force to replace them")
+	}
+
+	owner, err := a.getUser(meal.UserID)
+	if err != nil {
+		return err
+	}
+
+	details := ""
+	if force {
+		details = "force"
+	}
+
+	a.audit(c, auditReanalyzeMeal, &owner.ID, &meal.ID, details)
+
+	actor := staffActor(c)
+
+	go func() {
+		if _, err := a.runAISuggestions(actor, userLanguage(owner), owner.ID, meal.ID, force); err != nil {
+			log.Printf("Failed to reanalyze meal %d: %v", meal.ID, err)
+		}
+	}()
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// ListRecognitionFailures returns the latest recognition errors.
+func (a *API) ListRecognitionFailures(c echo.Context) error {
+	limit, err := listLimit(c)
+	if err != nil {
+		return err
+	}
+
+	failures, err := a.storage.ListRecognitionFailures(limit)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list recognition failures")
+	}
+
+	if failures == nil {
+		failures = []db.RecognitionFailure{}
+	}
+
+	return c.JSON(http.StatusOK, failures)
+}
+
+// ListAuditLog returns the latest audited actions, optionally only those on
+// or as the user given in "user_id".
+func (a *API) ListAuditLog(c echo.Context) error {
+	limit, err := listLimit(c)
+	if err != nil {
+		return err
+	}
+
+	var uid int64
+	if u := c.QueryParam("user_id"); u != "" {
+		uid, err = strconv.ParseInt(u, 10, 64)
+		if err != nil {
+			return terrors.BadRequest(err, "invalid user id")
+		}
+	}
+
+	entries, err := a.storage.ListAuditLog(uid, limit)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list audit log")
+	}
+
+	if entries == nil {
+		entries = []db.AuditLogEntry{}
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
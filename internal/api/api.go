@@ -1,9 +1,11 @@
 package api
 
 import (
+	"eatsome/internal/blob"
 	"eatsome/internal/db"
 	"eatsome/internal/recognition"
-	"eatsome/internal/s3"
+	"eatsome/internal/telegram"
+	"sync"
 	"time"
 )
 
@@ -13,33 +15,133 @@ type storager interface {
 	GetUserByChatID(chatID int64) (*db.User, error)
 	GetUserByID(id int64) (*db.User, error)
 	CreateUser(user db.User) error
+	UpdateUser(uid int64, user db.User) (*db.User, error)
+	SetAccountDeletionCode(uid int64, code string, expiresAt time.Time) error
+	DeleteUserByID(uid int64, code string, objects []db.ObjectDeletion) error
+	ListObjectDeletions() ([]db.ObjectDeletion, error)
+	DeleteObjectDeletion(id int64) error
+	CreateDataExport(uid int64) (*db.DataExport, error)
+	GetDataExport(uid, id int64) (*db.DataExport, error)
+	ListPendingDataExports() ([]db.DataExport, error)
+	ClaimDataExport(id int64) (bool, error)
+	FinishDataExport(id int64, objectKey string, exportErr error) error
+	ListUserMeals(uid int64) ([]db.Meal, error)
+	CreateMealImport(uid int64, source string, data []byte) (*db.MealImport, error)
+	GetMealImport(uid, id int64) (*db.MealImport, error)
+	ListPendingMealImports() ([]db.MealImport, error)
+	ClaimMealImport(id int64) ([]byte, error)
+	UpdateMealImportProgress(id int64, total, processed, imported int) error
+	FinishMealImport(id int64, importErr error) error
+	ImportMeals(uid int64, meals []db.Meal) ([]db.Meal, error)
+	StreamUserMeals(uid int64, startDate, endDate time.Time, fn func(db.Meal) error) error
+	ListUserComments(uid int64) ([]db.Comment, error)
+	ListNotifiableUsers() ([]db.User, error)
+	ClaimNotification(uid int64, kind, localDate string) (bool, error)
+	ReleaseNotification(uid int64, kind, localDate string) error
+	ClaimBotUpdate(updateID int64) (bool, error)
+	ReleaseBotUpdate(updateID int64) error
+	PurgeBotUpdates(receivedBefore time.Time) error
+	AddPhotoUpload(p db.PhotoUpload) error
+	GetPhotoUpload(uid int64, key string) (*db.PhotoUpload, error)
+	PhotoInUse(mealID int64, url string) (bool, error)
 	GetMealByID(id int64) (*db.Meal, error)
-	ListMeals(startDate, endDate time.Time) ([]db.Meal, error)
-	AddMeal(uid int64, meal db.Meal) (*db.Meal, error)
-	UpdateMeal(uid, id int64, meal db.Meal, tags []int) (*db.Meal, error)
+	GetVisibleMeal(viewerID, id int64) (*db.Meal, error)
+	ListMeals(viewerID int64, startDate, endDate time.Time) ([]db.Meal, error)
+	ListProfileMeals(viewerID, uid int64, startDate, endDate time.Time) ([]db.Meal, error)
+	ListMealComments(viewerID, mealID int64) ([]db.Comment, error)
+	AddComment(viewerID, mealID int64, text string) (*db.Comment, error)
+	ToggleMealReaction(viewerID, mealID int64, emoji string) (*db.MealReactions, error)
+	DeleteMealReaction(viewerID, mealID int64) (*db.MealReactions, error)
+	RefreshLeaderboards(period string, start, end time.Time) error
+	ListLeaderboard(viewerID int64, board, period string, periodStart time.Time, limit int) ([]db.LeaderboardEntry, error)
+	GetMealCounts(uid int64, minHealthRating int) (*db.MealCounts, error)
+	UnlockAchievement(uid int64, achievement string) (bool, error)
+	ListAchievements(uid int64) ([]db.Achievement, error)
+	ReportMeal(viewerID, mealID int64, reason string, details *string) (*db.Report, error)
+	ReportComment(viewerID, commentID int64, reason string, details *string) (*db.Report, error)
+	AppealMeal(uid, mealID int64, details *string) (*db.Report, error)
+	ApproveAppeals(mealID int64) error
+	ListOpenReports(limit int) ([]db.Report, error)
+	GetComment(id int64) (*db.Comment, error)
+	ResolveReport(moderatorID, reportID int64, status string) (*db.Report, error)
+	SearchUsers(query string, limit int) ([]db.User, error)
+	SetUserBanned(uid int64, banned bool) (*db.User, error)
+	SetUserRole(uid int64, role string) (*db.User, error)
+	AddAuditLogEntry(e db.AuditLogEntry) error
+	ListAuditLog(targetUserID int64, limit int) ([]db.AuditLogEntry, error)
+	AddRecognitionFailure(uid, mealID int64, message string) error
+	ListRecognitionFailures(limit int) ([]db.RecognitionFailure, error)
+	ListTags() ([]db.Tag, error)
+	CreateTag(name string) (*db.Tag, error)
+	RenameTag(id int64, name string) (*db.Tag, error)
+	DeleteTag(id int64) error
+	AddChange(c db.Change) error
+	ListChanges(entity string, entityID, ownerID int64) ([]db.Change, error)
+	GetReport(id int64) (*db.Report, error)
+	AddMeal(uid int64, meal db.Meal, photoKey string) (*db.Meal, error)
+	UpdateMeal(uid, id int64, version int, update db.MealUpdate) (*db.Meal, error)
+	SaveMealAnalysis(uid, mealID int64, analysis db.MealAnalysis) (*db.Meal, error)
+	SetMealIngredients(uid, mealID int64, version int, ingredients db.Ingredients) (*db.Meal, error)
+	SoftDeleteMeal(uid, mealID int64) (time.Time, error)
+	RestoreMeal(uid, mealID int64, deletedAfter time.Time) (*db.Meal, error)
+	ListDeletedMeals(deletedBefore time.Time) ([]db.Meal, error)
+	DeleteMeal(mealID int64) error
+	GetNutritionTotals(uid int64, startDate, endDate time.Time) (*db.NutritionTotals, error)
+	SetGoals(uid int64, effectiveFrom string, goals []db.Goal) error
+	GetGoalForDate(uid int64, date string, weekday time.Weekday) (*db.Goal, error)
+	ListGoals(uid int64) ([]db.Goal, error)
+	ListDailyTotals(uid int64, startDate, endDate time.Time, loc *time.Location) ([]db.DailyTotals, error)
+	GetBodyMetric(uid, id int64) (*db.BodyMetric, error)
+	AddBodyMetric(uid int64, m db.BodyMetric) (*db.BodyMetric, error)
+	UpdateBodyMetric(uid, id int64, m db.BodyMetric) (*db.BodyMetric, error)
+	DeleteBodyMetric(uid, id int64) error
+	ListBodyMetrics(uid int64, startDate, endDate time.Time) ([]db.BodyMetric, error)
+	AddBeverage(uid int64, b db.Beverage) (*db.Beverage, error)
+	DeleteBeverage(uid, id int64) error
+	ListBeverages(uid int64, startDate, endDate time.Time) ([]db.Beverage, error)
 }
 
 type API struct {
-	storage  storager
-	s3Client *s3.Client
+	storage storager
+	blobs   blob.BlobStore
+
+	readURLs readURLCache
 
 	recognizer *recognition.Client
+	bot        *telegram.Client
+	botUpdates chan telegram.Update
+
+	// analyses counts the recognitions running for each meal.
+	analysesMu sync.Mutex
+	analyses   map[int64]int
 
 	// Config struct
 	cfg Config
 }
 
 type Config struct {
-	BotToken  string
-	JWTSecret string
-	AssetsURL string
+	BotToken      string
+	WebhookSecret string
+	WebAppURL     string
+	JWTSecret     string
+	AssetsURL     string
+
+	// PrivatePhotos is set when the bucket is not publicly readable. Photo
+	// and avatar URLs in responses are then signed for a short time.
+	PrivatePhotos bool
+
+	// ReminderHour is the local hour after which users with no meals logged
+	// that day get a reminder. Zero disables reminders.
+	ReminderHour int
 }
 
-func New(storage storager, cfg Config, s3Client *s3.Client, recognizer *recognition.Client) *API {
+func New(storage storager, cfg Config, blobs blob.BlobStore, recognizer *recognition.Client, bot *telegram.Client) *API {
 	return &API{
 		storage:    storage,
 		cfg:        cfg,
-		s3Client:   s3Client,
+		blobs:      blobs,
 		recognizer: recognizer,
+		bot:        bot,
+		botUpdates: make(chan telegram.Update, botQueueSize),
 	}
 }
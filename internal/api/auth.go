@@ -77,12 +77,13 @@ func (a *API) AuthTelegram(c echo.Context) error {
 		}
 
 		create := db.User{
-			Username:     username,
-			ChatID:       data.User.ID,
-			FirstName:    first,
-			LastName:     last,
-			AvatarURL:    &imgUrl,
-			LanguageCode: &lang,
+			Username:             username,
+			ChatID:               data.User.ID,
+			FirstName:            first,
+			LastName:             last,
+			AvatarURL:            &imgUrl,
+			LanguageCode:         &lang,
+			NotificationsEnabled: true,
 		}
 
 		if err = a.storage.CreateUser(create); err != nil {
@@ -93,50 +94,53 @@ func (a *API) AuthTelegram(c echo.Context) error {
 		if err != nil {
 			return terrors.InternalServerError(err, "cannot get user")
 		}
+
+		a.logChange(userActor(user.ID), db.ChangeCreate, db.EntityUser, user.ID, &user.ID, nil, userSnapshot(user))
 	} else if err != nil {
 		return terrors.InternalServerError(err, "cannot get user")
 	}
 
-	token, err := generateJWT(user.ID, user.ChatID, a.cfg.JWTSecret)
+	if user.BannedAt != nil {
+		return terrors.Forbidden(ErrBanned, "user is banned")
+	}
+
+	token, err := generateJWT(user, 0, tokenTTL, a.cfg.JWTSecret)
 
 	if err != nil {
 		return terrors.InternalServerError(err, "jwt library error")
 	}
 
-	userResp := contract.UserResponse{
-		ID:                   user.ID,
-		Username:             user.Username,
-		LanguageCode:         user.LanguageCode,
-		ChatID:               user.ChatID,
-		CreatedAt:            user.CreatedAt,
-		UpdatedAt:            user.UpdatedAt,
-		LastSeenAt:           user.LastSeenAt,
-		NotificationsEnabled: user.NotificationsEnabled,
-		AvatarURL:            user.AvatarURL,
-		Title:                user.Title,
-	}
-
 	resp := &contract.UserAuthResponse{
 		Token: token,
-		User:  userResp,
+		User:  a.userResponse(user),
 	}
 
 	return c.JSON(http.StatusOK, resp)
 }
 
+// tokenTTL is how long tokens from AuthTelegram are valid.
+const tokenTTL = 24 * time.Hour
+
 type JWTClaims struct {
 	jwt.RegisteredClaims
-	UID    int64 `json:"uid"`
-	ChatID int64 `json:"chat_id"`
+	UID    int64  `json:"uid"`
+	ChatID int64  `json:"chat_id"`
+	Role   string `json:"role"`
+
+	// ImpersonatorID is set in tokens an admin uses to act as the user for
+	// support.
+	ImpersonatorID int64 `json:"impersonator_id,omitempty"`
 }
 
-func generateJWT(id int64, chatID int64, secretKey string) (string, error) {
+func generateJWT(user *db.User, impersonatorID int64, ttl time.Duration, secretKey string) (string, error) {
 	claims := &JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 		},
-		UID:    id,
-		ChatID: chatID,
+		UID:            user.ID,
+		ChatID:         user.ChatID,
+		Role:           user.Role,
+		ImpersonatorID: impersonatorID,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -165,7 +169,7 @@ func (a *API) uploadImageToS3(imgURL string, fileName string) error {
 		return fmt.Errorf("failed to read file: %v", err)
 	}
 
-	if _, err = a.s3Client.UploadFile(data, fileName); err != nil {
+	if _, err = a.blobs.UploadFile(data, fileName); err != nil {
 		return fmt.Errorf("failed to upload user avatar to S3: %v", err)
 	}
 
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type beverageNutrition struct {
+	Calories      float64
+	Proteins      float64
+	Fats          float64
+	Carbohydrates float64
+}
+
+// beverageTypes holds typical nutrition per 100 ml for quick-add beverages.
+var beverageTypes = map[string]beverageNutrition{
+	"water":  {},
+	"tea":    {Calories: 1, Carbohydrates: 0.2},
+	"coffee": {Calories: 2, Proteins: 0.3},
+	"latte":  {Calories: 54, Proteins: 3.4, Fats: 2.9, Carbohydrates: 4.6},
+	"juice":  {Calories: 45, Proteins: 0.5, Fats: 0.1, Carbohydrates: 10.4},
+	"soda":   {Calories: 42, Carbohydrates: 10.6},
+	"milk":   {Calories: 61, Proteins: 3.2, Fats: 3.3, Carbohydrates: 4.8},
+	"other":  {},
+}
+
+// AddBeverageRequest logs a drink. FoodInsights, when set, replaces the
+// typical nutrition of the beverage type for the whole volume.
+type AddBeverageRequest struct {
+	BeverageType string           `json:"beverage_type" validate:"required"`
+	VolumeML     int              `json:"volume_ml" validate:"required,min=1,max=5000"`
+	FoodInsights *db.FoodInsights `json:"food_insights"`
+	ConsumedAt   *time.Time       `json:"consumed_at"`
+}
+
+type HydrationSummaryResponse struct {
+	Date         string          `json:"date"`
+	VolumeML     int             `json:"volume_ml"`
+	TargetML     int             `json:"target_ml"`
+	ByType       map[string]int  `json:"by_type"`
+	FoodInsights db.FoodInsights `json:"food_insights"`
+	Beverages    []db.Beverage   `json:"beverages"`
+}
+
+func scaleNutrition(n beverageNutrition, volumeML int) *db.FoodInsights {
+	if n == (beverageNutrition{}) {
+		return nil
+	}
+
+	factor := float64(volumeML) / 100
+
+	return &db.FoodInsights{
+		Calories:      int(math.Round(n.Calories * factor)),
+		Proteins:      int(math.Round(n.Proteins * factor)),
+		Fats:          int(math.Round(n.Fats * factor)),
+		Carbohydrates: int(math.Round(n.Carbohydrates * factor)),
+	}
+}
+
+func (a *API) AddBeverage(c echo.Context) error {
+	uid := getUserID(c)
+
+	var req AddBeverageRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	typical, ok := beverageTypes[req.BeverageType]
+	if !ok {
+		return terrors.BadRequest(fmt.Errorf("unknown beverage type: %s", req.BeverageType), "unknown beverage type")
+	}
+
+	beverage := db.Beverage{
+		BeverageType: req.BeverageType,
+		VolumeML:     req.VolumeML,
+		FoodInsights: scaleNutrition(typical, req.VolumeML),
+		ConsumedAt:   time.Now(),
+	}
+
+	if req.FoodInsights != nil {
+		beverage.FoodInsights = req.FoodInsights
+	}
+
+	if req.ConsumedAt != nil {
+		beverage.ConsumedAt = *req.ConsumedAt
+	}
+
+	res, err := a.storage.AddBeverage(uid, beverage)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot add beverage")
+	}
+
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (a *API) DeleteBeverage(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	err := a.storage.DeleteBeverage(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "beverage not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot delete beverage")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetHydrationSummary returns the beverages of a local day with the total
+// volume against the user's water target.
+func (a *API) GetHydrationSummary(c echo.Context) error {
+	uid := getUserID(c)
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	loc := userLocation(user)
+	day := startOfDay(time.Now().In(loc))
+
+	if date := c.QueryParam("date"); date != "" {
+		day, err = time.ParseInLocation(dateLayout, date, loc)
+		if err != nil {
+			return terrors.BadRequest(err, "invalid date")
+		}
+	}
+
+	beverages, err := a.storage.ListBeverages(uid, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list beverages")
+	}
+
+	resp := HydrationSummaryResponse{
+		Date:      day.Format(dateLayout),
+		TargetML:  user.WaterTargetML,
+		ByType:    make(map[string]int),
+		Beverages: make([]db.Beverage, 0, len(beverages)),
+	}
+
+	for _, b := range beverages {
+		resp.VolumeML += b.VolumeML
+		resp.ByType[b.BeverageType] += b.VolumeML
+
+		if b.FoodInsights != nil {
+			resp.FoodInsights.Calories += b.FoodInsights.Calories
+			resp.FoodInsights.Proteins += b.FoodInsights.Proteins
+			resp.FoodInsights.Fats += b.FoodInsights.Fats
+			resp.FoodInsights.Carbohydrates += b.FoodInsights.Carbohydrates
+		}
+
+		resp.Beverages = append(resp.Beverages, b)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,365 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/nutrition"
+	"eatsome/internal/terrors"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type BodyMetricRequest struct {
+	Weight         *float64   `json:"weight" validate:"omitempty,gt=0,lt=1000"`
+	WeightUnit     string     `json:"weight_unit" validate:"omitempty,oneof=kg lb"`
+	BodyFatPercent *float64   `json:"body_fat_percent" validate:"omitempty,gt=0,lt=100"`
+	Waist          *float64   `json:"waist" validate:"omitempty,gt=0,lt=500"`
+	WaistUnit      string     `json:"waist_unit" validate:"omitempty,oneof=cm in"`
+	MeasuredAt     *time.Time `json:"measured_at"`
+}
+
+type BodyTrendPoint struct {
+	MeasuredAt        time.Time `json:"measured_at"`
+	WeightKg          *float64  `json:"weight_kg"`
+	WeightKgAvg       *float64  `json:"weight_kg_avg"`
+	BodyFatPercent    *float64  `json:"body_fat_percent"`
+	BodyFatPercentAvg *float64  `json:"body_fat_percent_avg"`
+	WaistCm           *float64  `json:"waist_cm"`
+	WaistCmAvg        *float64  `json:"waist_cm_avg"`
+}
+
+type TDEEResponse struct {
+	Days           int      `json:"days"`
+	LoggedDays     int      `json:"logged_days"`
+	Measurements   int      `json:"measurements"`
+	AverageIntake  float64  `json:"average_intake"`
+	WeightChangeKg *float64 `json:"weight_change_kg"`
+	EstimatedTDEE  *float64 `json:"estimated_tdee"`
+}
+
+func (req BodyMetricRequest) toBodyMetric() (db.BodyMetric, error) {
+	if req.Weight == nil && req.BodyFatPercent == nil && req.Waist == nil {
+		return db.BodyMetric{}, errors.New("no measurements")
+	}
+
+	m := db.BodyMetric{
+		Weight:         req.Weight,
+		WeightUnit:     req.WeightUnit,
+		BodyFatPercent: req.BodyFatPercent,
+		Waist:          req.Waist,
+		WaistUnit:      req.WaistUnit,
+		MeasuredAt:     time.Now(),
+	}
+
+	if m.WeightUnit == "" {
+		m.WeightUnit = "kg"
+	}
+
+	if m.WaistUnit == "" {
+		m.WaistUnit = "cm"
+	}
+
+	if req.MeasuredAt != nil {
+		m.MeasuredAt = *req.MeasuredAt
+	}
+
+	return m, nil
+}
+
+func weightKg(m db.BodyMetric) *float64 {
+	if m.Weight == nil {
+		return nil
+	}
+
+	kg := *m.Weight
+	if m.WeightUnit == "lb" {
+		kg = nutrition.PoundsToKg(kg)
+	}
+
+	return &kg
+}
+
+func waistCm(m db.BodyMetric) *float64 {
+	if m.Waist == nil {
+		return nil
+	}
+
+	cm := *m.Waist
+	if m.WaistUnit == "in" {
+		cm = nutrition.InchesToCm(cm)
+	}
+
+	return &cm
+}
+
+func bodyFatPercent(m db.BodyMetric) *float64 {
+	return m.BodyFatPercent
+}
+
+func (a *API) bindBodyMetric(c echo.Context) (db.BodyMetric, error) {
+	var req BodyMetricRequest
+	if err := c.Bind(&req); err != nil {
+		return db.BodyMetric{}, terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return db.BodyMetric{}, err
+	}
+
+	m, err := req.toBodyMetric()
+	if err != nil {
+		return db.BodyMetric{}, terrors.BadRequest(err, "weight, body fat or waist is required")
+	}
+
+	return m, nil
+}
+
+func (a *API) CreateBodyMetric(c echo.Context) error {
+	uid := getUserID(c)
+
+	m, err := a.bindBodyMetric(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := a.storage.AddBodyMetric(uid, m)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot add body metric")
+	}
+
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (a *API) GetBodyMetric(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	res, err := a.storage.GetBodyMetric(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "body metric not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get body metric")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (a *API) UpdateBodyMetric(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	m, err := a.bindBodyMetric(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := a.storage.UpdateBodyMetric(uid, id, m)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "body metric not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot update body metric")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (a *API) DeleteBodyMetric(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	err := a.storage.DeleteBodyMetric(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "body metric not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot delete body metric")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *API) ListBodyMetrics(c echo.Context) error {
+	uid := getUserID(c)
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseDateRange(c, userLocation(user), 90, maxBodyRangeDays)
+	if err != nil {
+		return err
+	}
+
+	metrics, err := a.storage.ListBodyMetrics(uid, start, end)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list body metrics")
+	}
+
+	if metrics == nil {
+		metrics = make([]db.BodyMetric, 0)
+	}
+
+	return c.JSON(http.StatusOK, metrics)
+}
+
+// movingAverages computes the moving average of one measurement and maps it
+// back to the metrics it was taken from.
+func movingAverages(metrics []db.BodyMetric, value func(db.BodyMetric) *float64, window time.Duration) map[int]float64 {
+	var points []nutrition.Point
+	var indexes []int
+
+	for i, m := range metrics {
+		if v := value(m); v != nil {
+			points = append(points, nutrition.Point{Time: m.MeasuredAt, Value: *v})
+			indexes = append(indexes, i)
+		}
+	}
+
+	avgs := make(map[int]float64, len(points))
+	for i, avg := range nutrition.MovingAverage(points, window) {
+		avgs[indexes[i]] = avg
+	}
+
+	return avgs
+}
+
+func averageAt(avgs map[int]float64, i int) *float64 {
+	avg, ok := avgs[i]
+	if !ok {
+		return nil
+	}
+
+	return &avg
+}
+
+func (a *API) GetBodyMetricsTrend(c echo.Context) error {
+	uid := getUserID(c)
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseDateRange(c, userLocation(user), 90, maxBodyRangeDays)
+	if err != nil {
+		return err
+	}
+
+	windowDays := 7
+	if w := c.QueryParam("window"); w != "" {
+		windowDays, err = strconv.Atoi(w)
+		if err != nil || windowDays < 1 || windowDays > 60 {
+			return terrors.BadRequest(errors.New("invalid window"), "window must be between 1 and 60 days")
+		}
+	}
+
+	window := time.Duration(windowDays) * 24 * time.Hour
+
+	// Measurements from one window before the range make the first
+	// averages in the range complete.
+	metrics, err := a.storage.ListBodyMetrics(uid, start.Add(-window), end)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list body metrics")
+	}
+
+	weightAvgs := movingAverages(metrics, weightKg, window)
+	bodyFatAvgs := movingAverages(metrics, bodyFatPercent, window)
+	waistAvgs := movingAverages(metrics, waistCm, window)
+
+	resp := make([]BodyTrendPoint, 0, len(metrics))
+
+	for i, m := range metrics {
+		if m.MeasuredAt.Before(start) {
+			continue
+		}
+
+		resp = append(resp, BodyTrendPoint{
+			MeasuredAt:        m.MeasuredAt,
+			WeightKg:          weightKg(m),
+			WeightKgAvg:       averageAt(weightAvgs, i),
+			BodyFatPercent:    m.BodyFatPercent,
+			BodyFatPercentAvg: averageAt(bodyFatAvgs, i),
+			WaistCm:           waistCm(m),
+			WaistCmAvg:        averageAt(waistAvgs, i),
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// EstimateTDEE estimates actual daily energy expenditure from the weight
+// trend and the calories logged over a rolling window of days.
+func (a *API) EstimateTDEE(c echo.Context) error {
+	uid := getUserID(c)
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	days := 28
+	if d := c.QueryParam("days"); d != "" {
+		days, err = strconv.Atoi(d)
+		if err != nil || days < 7 || days > 180 {
+			return terrors.BadRequest(errors.New("invalid days"), "days must be between 7 and 180")
+		}
+	}
+
+	loc := userLocation(user)
+	end := startOfDay(time.Now().In(loc)).AddDate(0, 0, 1)
+	start := end.AddDate(0, 0, -days)
+
+	metrics, err := a.storage.ListBodyMetrics(uid, start, end)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list body metrics")
+	}
+
+	dailyTotals, err := a.storage.ListDailyTotals(uid, start, end, loc)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot get daily totals")
+	}
+
+	resp := TDEEResponse{
+		Days: days,
+	}
+
+	// Days without any meal logged are left out: they are missing data,
+	// not days of fasting.
+	var intake int
+	for _, d := range dailyTotals {
+		if d.Meals == 0 {
+			continue
+		}
+
+		resp.LoggedDays++
+		intake += d.Totals.Calories
+	}
+
+	if resp.LoggedDays > 0 {
+		resp.AverageIntake = float64(intake) / float64(resp.LoggedDays)
+	}
+
+	var weights []nutrition.Point
+	for _, m := range metrics {
+		if kg := weightKg(m); kg != nil {
+			weights = append(weights, nutrition.Point{Time: m.MeasuredAt, Value: *kg})
+		}
+	}
+
+	resp.Measurements = len(weights)
+
+	slope, err := nutrition.Slope(weights)
+	if err != nil || resp.LoggedDays == 0 {
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	change := slope * float64(days)
+	tdee := nutrition.EstimateTDEE(resp.AverageIntake, slope)
+
+	resp.WeightChangeKg = &change
+	resp.EstimatedTDEE = &tdee
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"eatsome/internal/db"
+	"eatsome/internal/telegram"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// botWorkers is how many bot updates are handled at once, and
+	// botQueueSize how many can wait for a worker.
+	botWorkers   = 4
+	botQueueSize = 100
+
+	// botUpdateRetention is how long handled update IDs are remembered.
+	// Telegram gives up redelivering an update after a day.
+	botUpdateRetention = 48 * time.Hour
+)
+
+type botContent struct {
+	NotRegistered  string
+	Help           string
+	Analyzing      string
+	Analyzed       string
+	AnalysisFailed string
+	Spam           string
+	Result         string
+	Summary        string
+	Today          string
+	Week           string
+	Digest         string
+	GoalProgress   string
+	Reminder       string
+	OpenApp        string
+	OpenMeal       string
+	// AchievementUnlocked is formatted with a name from Achievements.
+	AchievementUnlocked string
+	Achievements        map[string]string
+}
+
+func getBotContent(language string) botContent {
+	if language == "ru" {
+		return botContent{
+			NotRegistered:       "Откройте приложение, чтобы создать дневник, а затем отправьте мне фото еды.",
+			Help:                "Отправьте мне фото еды, можно с подписью, и я добавлю его в дневник.\n/today — итоги за сегодня\n/week — итоги за 7 дней",
+			Analyzing:           "Принято! Анализирую блюдо…",
+			Analyzed:            "Блюдо проанализировано",
+			AnalysisFailed:      "Не удалось распознать фото. Блюдо сохранено, его можно отредактировать в приложении.",
+			Spam:                "Похоже, на фото нет еды.",
+			Result:              "%s\n%d ккал · Б %d г · Ж %d г · У %d г",
+			Summary:             "%s: приемов пищи %d\n%d ккал · Б %d г · Ж %d г · У %d г",
+			Today:               "Сегодня",
+			Week:                "Последние 7 дней",
+			Digest:              "Итоги дня",
+			GoalProgress:        "Цель: %d / %d ккал · Б %d / %d г · Ж %d / %d г · У %d / %d г",
+			Reminder:            "Сегодня вы еще ничего не записали. Отправьте мне фото еды, чтобы не потерять дневник.",
+			OpenApp:             "Открыть приложение",
+			OpenMeal:            "Открыть блюдо",
+			AchievementUnlocked: "🏆 Новое достижение: %s",
+			Achievements: map[string]string{
+				db.AchievementFirstMeal:     "первое блюдо в дневнике",
+				db.AchievementStreak7:       "7 дней подряд",
+				db.AchievementHealthyMeals:  "30 полезных блюд",
+				db.AchievementProteinStreak: "цель по белку 5 дней подряд",
+			},
+		}
+	}
+	return botContent{
+		NotRegistered:       "Open the app once to create your diary, then send me a photo of your meal.",
+		Help:                "Send me a photo of your meal, optionally with a caption, and I will log it.\n/today — today's summary\n/week — last 7 days",
+		Analyzing:           "Got it! Analyzing your meal…",
+		Analyzed:            "Your meal is analyzed",
+		AnalysisFailed:      "Sorry, I could not analyze this photo. The meal is saved, you can edit it in the app.",
+		Spam:                "This does not look like food.",
+		Result:              "%s\n%d kcal · P %d g · F %d g · C %d g",
+		Summary:             "%s: %d meals\n%d kcal · P %d g · F %d g · C %d g",
+		Today:               "Today",
+		Week:                "Last 7 days",
+		Digest:              "Your day",
+		GoalProgress:        "Goal: %d / %d kcal · P %d / %d g · F %d / %d g · C %d / %d g",
+		Reminder:            "You have not logged any meals today. Send me a photo of your meal to keep your diary up to date.",
+		OpenApp:             "Open app",
+		OpenMeal:            "Open meal",
+		AchievementUnlocked: "🏆 Achievement unlocked: %s",
+		Achievements: map[string]string{
+			db.AchievementFirstMeal:     "first meal logged",
+			db.AchievementStreak7:       "7-day streak",
+			db.AchievementHealthyMeals:  "30 healthy meals",
+			db.AchievementProteinStreak: "protein goal 5 days in a row",
+		},
+	}
+}
+
+// TelegramWebhook receives bot updates. Telegram signs every delivery with the
+// secret token passed to setWebhook.
+func (a *API) TelegramWebhook(c echo.Context) error {
+	secret := c.Request().Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if a.cfg.WebhookSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(a.cfg.WebhookSecret)) != 1 {
+		return terrors.Unauthorized(errors.New("invalid webhook secret"), "invalid webhook secret")
+	}
+
+	var update telegram.Update
+	if err := c.Bind(&update); err != nil {
+		return terrors.BadRequest(err, "failed to bind update")
+	}
+
+	claimed, err := a.storage.ClaimBotUpdate(update.UpdateID)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot claim update")
+	}
+
+	if !claimed {
+		return c.NoContent(http.StatusOK)
+	}
+
+	// Telegram redelivers updates that are not acknowledged quickly, and
+	// recognition takes longer than that, so the update is handled after
+	// the response is sent. When the queue is full Telegram is asked to
+	// deliver the update again later.
+	select {
+	case a.botUpdates <- update:
+	default:
+		if err := a.storage.ReleaseBotUpdate(update.UpdateID); err != nil {
+			log.Printf("Failed to release bot update %d: %v", update.UpdateID, err)
+		}
+
+		return c.NoContent(http.StatusServiceUnavailable)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// StartBotWorkers handles queued bot updates until ctx is done.
+func (a *API) StartBotWorkers(ctx context.Context) {
+	for i := 0; i < botWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case update := <-a.botUpdates:
+					a.handleBotUpdate(update)
+				}
+			}
+		}()
+	}
+}
+
+// PurgeBotUpdates forgets update IDs that Telegram no longer redelivers.
+func (a *API) PurgeBotUpdates(ctx context.Context) error {
+	return a.storage.PurgeBotUpdates(time.Now().Add(-botUpdateRetention))
+}
+
+func botCommand(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return ""
+	}
+
+	command, _, _ := strings.Cut(fields[0], "@")
+
+	return strings.ToLower(command)
+}
+
+func (a *API) handleBotUpdate(update telegram.Update) {
+	msg := update.Message
+	if msg == nil || msg.From == nil {
+		return
+	}
+
+	user, err := a.storage.GetUserByChatID(msg.From.ID)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		content := getBotContent(msg.From.LanguageCode)
+		if err := a.sendBotMessage(msg.Chat.ID, content.NotRegistered, content.OpenApp, a.cfg.WebAppURL); err != nil {
+			log.Printf("Failed to reply to unregistered user: %v", err)
+		}
+		return
+	} else if err != nil {
+		log.Printf("Failed to get user for bot update %d: %v", update.UpdateID, err)
+		return
+	}
+
+	if user.BannedAt != nil {
+		return
+	}
+
+	content := getBotContent(userLanguage(user))
+
+	today := startOfDay(time.Now().In(userLocation(user)))
+
+	switch {
+	case len(msg.Photo) > 0:
+		err = a.handleBotPhoto(user, msg, content)
+	case botCommand(msg.Text) == "/today":
+		err = a.sendBotSummary(user, msg.Chat.ID, content, content.Today, today, today.AddDate(0, 0, 1))
+	case botCommand(msg.Text) == "/week":
+		err = a.sendBotSummary(user, msg.Chat.ID, content, content.Week, today.AddDate(0, 0, -6), today.AddDate(0, 0, 1))
+	default:
+		err = a.sendBotMessage(msg.Chat.ID, content.Help, content.OpenApp, a.cfg.WebAppURL)
+	}
+
+	if err != nil {
+		log.Printf("Failed to handle bot update %d: %v", update.UpdateID, err)
+	}
+}
+
+func (a *API) handleBotPhoto(user *db.User, msg *telegram.Message, content botContent) error {
+	// Telegram lists photo sizes in ascending order, the last one is the original.
+	photo := msg.Photo[len(msg.Photo)-1]
+
+	file, err := a.bot.GetFile(photo.FileID)
+	if err != nil {
+		return fmt.Errorf("failed to get photo file: %w", err)
+	}
+
+	data, err := a.bot.DownloadFile(file.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to download photo: %w", err)
+	}
+
+	base := fmt.Sprintf("%d/%s/%s", user.ID, time.Now().Format("2006-01-02"), randomString(10))
+
+	upload, err := a.storePhoto(user.ID, data, base)
+	if err != nil {
+		return fmt.Errorf("failed to store photo: %w", err)
+	}
+
+	var text *string
+	if msg.Caption != "" {
+		text = &msg.Caption
+	}
+
+	meal, err := a.addMeal(userActor(user.ID), user, upload, text, time.Unix(msg.Date, 0), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to add meal: %w", err)
+	}
+
+	if err := a.sendBotMessage(msg.Chat.ID, content.Analyzing, "", ""); err != nil {
+		log.Printf("Failed to send analyzing message: %v", err)
+	}
+
+	meal, err = a.runAISuggestions(systemActor, userLanguage(user), user.ID, meal.ID, false)
+	if err != nil {
+		if sendErr := a.sendBotMessage(msg.Chat.ID, content.AnalysisFailed, content.OpenApp, a.cfg.WebAppURL); sendErr != nil {
+			log.Printf("Failed to send analysis failure message: %v", sendErr)
+		}
+		return fmt.Errorf("failed to analyze meal: %w", err)
+	}
+
+	// The result is sent below instead of through analyzeMeal, which would
+	// notify the user a second time.
+	a.checkAchievements(user.ID)
+
+	return a.sendBotMessage(msg.Chat.ID, mealResultText(content, meal), content.OpenMeal, a.mealLink(meal.ID))
+}
+
+func mealResultText(content botContent, meal *db.Meal) string {
+	if meal.IsSpam {
+		return content.Spam
+	}
+
+	var dishName string
+	if meal.DishName != nil {
+		dishName = *meal.DishName
+	}
+
+	var insights db.FoodInsights
+	if meal.FoodInsights != nil {
+		insights = *meal.FoodInsights
+	}
+
+	return fmt.Sprintf(content.Result, dishName, insights.Calories, insights.Proteins, insights.Fats, insights.Carbohydrates)
+}
+
+func (a *API) sendBotSummary(user *db.User, chatID int64, content botContent, title string, start, end time.Time) error {
+	totals, err := a.storage.GetNutritionTotals(user.ID, start, end)
+	if err != nil {
+		return fmt.Errorf("failed to get nutrition totals: %w", err)
+	}
+
+	text := fmt.Sprintf(content.Summary, title, totals.Meals,
+		totals.Totals.Calories, totals.Totals.Proteins, totals.Totals.Fats, totals.Totals.Carbohydrates)
+
+	return a.sendBotMessage(chatID, text, content.OpenApp, a.cfg.WebAppURL)
+}
+
+// mealLink returns a Mini App deep link that opens the meal, or an empty
+// string when no Mini App URL is configured.
+func (a *API) mealLink(mealID int64) string {
+	if a.cfg.WebAppURL == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s?startapp=meal_%d", a.cfg.WebAppURL, mealID)
+}
+
+func (a *API) sendBotMessage(chatID int64, text, buttonText, buttonURL string) error {
+	req := telegram.SendMessageRequest{
+		ChatID: chatID,
+		Text:   text,
+	}
+
+	if buttonText != "" && buttonURL != "" {
+		req.ReplyMarkup = &telegram.InlineKeyboardMarkup{
+			InlineKeyboard: [][]telegram.InlineKeyboardButton{{{Text: buttonText, URL: buttonURL}}},
+		}
+	}
+
+	return a.bot.SendMessage(req)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"eatsome/internal/contract"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ignoredChangeFields change on every write or are not data of the entity,
+// so they are left out of diffs.
+var ignoredChangeFields = map[string]bool{
+	"updated_at":   true,
+	"last_seen_at": true,
+	"version":      true,
+	"reactions":    true,
+	"my_reaction":  true,
+}
+
+var systemActor = db.Actor{Type: db.ActorSystem}
+
+// userChange is the form of users in the change log.
+type userChange struct {
+	contract.UserResponse
+	BannedAt *time.Time `json:"banned_at"`
+}
+
+func userSnapshot(user *db.User) userChange {
+	return userChange{UserResponse: toUserResponse(user), BannedAt: user.BannedAt}
+}
+
+type fieldChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+func userActor(uid int64) db.Actor {
+	return db.Actor{Type: db.ActorUser, ID: &uid}
+}
+
+// requestActor returns who makes a request: the user, or the admin
+// impersonating them.
+func requestActor(c echo.Context) db.Actor {
+	claims, _ := ClaimsFromContext(c.Request().Context())
+
+	if claims.ImpersonatorID != 0 {
+		return db.Actor{Type: db.ActorAdmin, ID: &claims.ImpersonatorID}
+	}
+
+	return userActor(claims.UID)
+}
+
+// staffActor returns the moderator or admin making a request on someone
+// else's data.
+func staffActor(c echo.Context) db.Actor {
+	uid := getUserID(c)
+	return db.Actor{Type: db.ActorAdmin, ID: &uid}
+}
+
+func jsonFields(v interface{}) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// changeDiff returns the fields that differ between the JSON forms of
+// before and after. Either is nil for creations and deletions.
+func changeDiff(before, after interface{}) (map[string]fieldChange, error) {
+	old, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]fieldChange{}
+	null := json.RawMessage("null")
+
+	for _, fields := range []map[string]json.RawMessage{old, cur} {
+		for name := range fields {
+			if ignoredChangeFields[name] {
+				continue
+			}
+
+			change := fieldChange{Before: old[name], After: cur[name]}
+			if change.Before == nil {
+				change.Before = null
+			}
+			if change.After == nil {
+				change.After = null
+			}
+
+			if !bytes.Equal(change.Before, change.After) {
+				diff[name] = change
+			}
+		}
+	}
+
+	return diff, nil
+}
+
+// logChange appends a change of an entity owned by ownerID to the change
+// log. Updates that change nothing are not logged. Failing to log a change
+// does not fail it.
+func (a *API) logChange(actor db.Actor, action, entity string, entityID int64, ownerID *int64, before, after interface{}) {
+	diff, err := changeDiff(before, after)
+	if err != nil {
+		log.Printf("Failed to diff %s %d: %v", entity, entityID, err)
+		return
+	}
+
+	if len(diff) == 0 && action == db.ChangeUpdate {
+		return
+	}
+
+	data, err := json.Marshal(diff)
+	if err != nil {
+		log.Printf("Failed to encode diff of %s %d: %v", entity, entityID, err)
+		return
+	}
+
+	change := db.Change{
+		Actor:    actor,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		OwnerID:  ownerID,
+		Diff:     data,
+	}
+
+	if err := a.storage.AddChange(change); err != nil {
+		log.Printf("Failed to log %s of %s %d: %v", action, entity, entityID, err)
+	}
+}
+
+// GetMealHistory returns the changes of one of the caller's meals, oldest
+// first, including those of a meal that has since been deleted. Moderators
+// and admins are not identified.
+func (a *API) GetMealHistory(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	changes, err := a.storage.ListChanges(db.EntityMeal, id, uid)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list meal history")
+	}
+
+	if len(changes) == 0 {
+		if _, err := a.getOwnMeal(uid, id); err != nil {
+			return err
+		}
+	}
+
+	for i := range changes {
+		if changes[i].Actor.Type != db.ActorUser {
+			changes[i].Actor.ID = nil
+		}
+	}
+
+	return c.JSON(http.StatusOK, changes)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+type AddCommentRequest struct {
+	Text string `json:"text" validate:"required,max=1000"`
+}
+
+// ListComments returns the comments on a meal the caller may see.
+func (a *API) ListComments(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	comments, err := a.storage.ListMealComments(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot list comments")
+	}
+
+	if comments == nil {
+		comments = []db.Comment{}
+	}
+
+	return c.JSON(http.StatusOK, comments)
+}
+
+// AddComment comments on a meal the caller may see.
+func (a *API) AddComment(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req AddCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	comment, err := a.storage.AddComment(uid, id, req.Text)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot add comment")
+	}
+
+	a.logChange(requestActor(c), db.ChangeCreate, db.EntityComment, comment.ID, &uid, nil, comment)
+
+	return c.JSON(http.StatusCreated, comment)
+}
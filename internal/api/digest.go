@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"eatsome/internal/db"
+	"eatsome/internal/telegram"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	notificationDigest   = "digest"
+	notificationReminder = "reminder"
+)
+
+// SendScheduledNotifications sends daily digests and logging reminders that
+// are due. It is called by the scheduler every minute.
+func (a *API) SendScheduledNotifications(ctx context.Context) error {
+	users, err := a.storage.ListNotifiableUsers()
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	now := time.Now()
+
+	for i := range users {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		user := &users[i]
+		local := now.In(userLocation(user))
+
+		if user.DigestEnabled && local.Format("15:04") >= user.DigestTime {
+			if err := a.sendDigest(user, local); err != nil {
+				log.Printf("Failed to send digest to user %d: %v", user.ID, err)
+			}
+		}
+
+		if a.cfg.ReminderHour > 0 && local.Hour() >= a.cfg.ReminderHour {
+			if err := a.sendReminder(user, local); err != nil {
+				log.Printf("Failed to send reminder to user %d: %v", user.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *API) sendDigest(user *db.User, local time.Time) error {
+	return a.sendScheduled(user, notificationDigest, local, func() (string, error) {
+		return a.digestText(user, local)
+	})
+}
+
+func (a *API) digestText(user *db.User, local time.Time) (string, error) {
+	progress, err := a.dailyProgress(user, startOfDay(local))
+	if err != nil {
+		return "", err
+	}
+
+	content := getBotContent(userLanguage(user))
+
+	consumed := progress.Consumed
+
+	text := fmt.Sprintf(content.Summary, content.Digest, progress.Meals,
+		consumed.Calories, consumed.Proteins, consumed.Fats, consumed.Carbohydrates)
+
+	if goal := progress.Goal; goal != nil {
+		text += "\n" + fmt.Sprintf(content.GoalProgress,
+			consumed.Calories, goal.Calories, consumed.Proteins, goal.Proteins,
+			consumed.Fats, goal.Fats, consumed.Carbohydrates, goal.Carbohydrates)
+	}
+
+	return text, nil
+}
+
+// sendReminder reminds users who logged nothing today. Once they have, no
+// reminder is due for the rest of the day.
+func (a *API) sendReminder(user *db.User, local time.Time) error {
+	return a.sendScheduled(user, notificationReminder, local, func() (string, error) {
+		day := startOfDay(local)
+
+		totals, err := a.storage.GetNutritionTotals(user.ID, day, day.AddDate(0, 0, 1))
+		if err != nil {
+			return "", err
+		}
+
+		if totals.Meals > 0 {
+			return "", nil
+		}
+
+		return getBotContent(userLanguage(user)).Reminder, nil
+	})
+}
+
+// sendScheduled delivers a notification at most once per user, kind and
+// local day. The day is claimed before compose builds the text, so users
+// already notified cost no work on later ticks; an empty text sends nothing.
+// Failed sends are released for a retry on the next tick unless Telegram
+// rejected the message for good, e.g. because the user blocked the bot.
+func (a *API) sendScheduled(user *db.User, kind string, local time.Time, compose func() (string, error)) error {
+	localDate := local.Format(dateLayout)
+
+	claimed, err := a.storage.ClaimNotification(user.ID, kind, localDate)
+	if err != nil || !claimed {
+		return err
+	}
+
+	text, err := compose()
+	if err != nil {
+		if releaseErr := a.storage.ReleaseNotification(user.ID, kind, localDate); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
+
+		return err
+	}
+
+	if text == "" {
+		return nil
+	}
+
+	content := getBotContent(userLanguage(user))
+
+	sendErr := a.sendBotMessage(user.ChatID, text, content.OpenApp, a.cfg.WebAppURL)
+	if sendErr == nil {
+		return nil
+	}
+
+	var tgErr *telegram.Error
+	if errors.As(sendErr, &tgErr) && tgErr.Code >= 400 && tgErr.Code < 500 && tgErr.Code != http.StatusTooManyRequests {
+		return sendErr
+	}
+
+	if err := a.storage.ReleaseNotification(user.ID, kind, localDate); err != nil {
+		return errors.Join(sendErr, err)
+	}
+
+	return sendErr
+}
@@ -0,0 +1,488 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"eatsome/internal/blob"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportLinkTTL is how long a download link of a finished export is valid.
+const exportLinkTTL = time.Hour
+
+type DataExportResponse struct {
+	db.DataExport
+	DownloadURL *string `json:"download_url"`
+}
+
+// CreateDataExport queues an archive of the caller's profile, meals,
+// ingredients, comments and photos. The archive is built in the background;
+// its status and download link are available from GetDataExport.
+func (a *API) CreateDataExport(c echo.Context) error {
+	uid := getUserID(c)
+
+	export, err := a.storage.CreateDataExport(uid)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot create export")
+	}
+
+	go a.runDataExport(context.Background(), *export)
+
+	return c.JSON(http.StatusAccepted, DataExportResponse{DataExport: *export})
+}
+
+func (a *API) GetDataExport(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	export, err := a.storage.GetDataExport(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "export not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get export")
+	}
+
+	resp := DataExportResponse{DataExport: *export}
+
+	if export.Status == db.JobStatusDone && export.ObjectKey != nil {
+		url, err := a.blobs.GetPresignedDownloadURL(*export.ObjectKey, exportLinkTTL)
+		if err != nil {
+			return terrors.InternalServerError(err, "cannot get download url")
+		}
+		resp.DownloadURL = &url
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// ProcessDataExports builds exports left pending or abandoned, e.g. by a
+// restart. It is called by the scheduler.
+func (a *API) ProcessDataExports(ctx context.Context) error {
+	exports, err := a.storage.ListPendingDataExports()
+	if err != nil {
+		return fmt.Errorf("failed to list exports: %w", err)
+	}
+
+	for _, export := range exports {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		a.runDataExport(ctx, export)
+	}
+
+	return nil
+}
+
+func (a *API) runDataExport(ctx context.Context, export db.DataExport) {
+	claimed, err := a.storage.ClaimDataExport(export.ID)
+	if err != nil {
+		log.Printf("Failed to claim export %d: %v", export.ID, err)
+		return
+	} else if !claimed {
+		return
+	}
+
+	key, err := exportObjectKey(export.UserID)
+	if err == nil {
+		err = a.uploadDataExport(ctx, export.UserID, key)
+	}
+
+	if err != nil {
+		log.Printf("Failed to build export %d: %v", export.ID, err)
+	}
+
+	if finishErr := a.storage.FinishDataExport(export.ID, key, err); finishErr != nil {
+		log.Printf("Failed to finish export %d: %v", export.ID, finishErr)
+	}
+}
+
+// exportObjectKey returns a key for a user's export archive. Assets are
+// served by key from the bucket's public domain, so the key has a random
+// part that is only handed out in signed download links.
+func exportObjectKey(uid int64) (string, error) {
+	id, err := gonanoid.New(32)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d/exports/%s.zip", uid, id), nil
+}
+
+// uploadDataExport streams the export archive of a user into the bucket as
+// it is built.
+func (a *API) uploadDataExport(ctx context.Context, uid int64, key string) error {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(a.writeDataExport(ctx, uid, pw))
+	}()
+
+	err := a.blobs.UploadStream(ctx, pr, key)
+
+	// Unblocks the archive writer if the upload stopped reading early.
+	pr.CloseWithError(err)
+
+	return err
+}
+
+// writeDataExport writes a ZIP archive with the user's data as JSON and CSV
+// files and their meal photos under photos/. Photos are downloaded one at a
+// time as they are written. Photos missing from the bucket are listed in
+// missing_photos.csv.
+func (a *API) writeDataExport(ctx context.Context, uid int64, out io.Writer) error {
+	user, err := a.storage.GetUserByID(uid)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	meals, err := a.storage.ListUserMeals(uid)
+	if err != nil {
+		return fmt.Errorf("failed to list meals: %w", err)
+	}
+
+	comments, err := a.storage.ListUserComments(uid)
+	if err != nil {
+		return fmt.Errorf("failed to list comments: %w", err)
+	}
+
+	zw := zip.NewWriter(out)
+
+	if err := writeZipJSON(zw, "profile.json", toUserResponse(user)); err != nil {
+		return err
+	}
+
+	if err := writeZipJSON(zw, "meals.json", meals); err != nil {
+		return err
+	}
+
+	if err := writeZipJSON(zw, "comments.json", comments); err != nil {
+		return err
+	}
+
+	if err := writeZipCSV(zw, "meals.csv", mealRecords(meals)); err != nil {
+		return err
+	}
+
+	if err := writeZipCSV(zw, "ingredients.csv", ingredientRecords(meals)); err != nil {
+		return err
+	}
+
+	if err := writeZipCSV(zw, "comments.csv", commentRecords(comments)); err != nil {
+		return err
+	}
+
+	// A missing photo would fail every retry of the export, so it is only
+	// listed.
+	missing := [][]string{{"meal_id", "photo_url"}}
+
+	for _, meal := range meals {
+		key, ok := a.objectKey(meal.PhotoURL)
+		if !ok {
+			continue
+		}
+
+		photo, err := a.blobs.GetFile(ctx, key)
+		if err != nil && errors.Is(err, blob.ErrNotFound) {
+			missing = append(missing, []string{strconv.FormatInt(meal.ID, 10), meal.PhotoURL})
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to download photo of meal %d: %w", meal.ID, err)
+		}
+
+		w, err := zw.Create(fmt.Sprintf("photos/%d%s", meal.ID, path.Ext(key)))
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(photo); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 1 {
+		if err := writeZipCSV(zw, "missing_photos.csv", missing); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+func writeZipCSV(zw *zip.Writer, name string, records [][]string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+func formatOptional[T any](v *T) string {
+	if v == nil {
+		return ""
+	}
+
+	return fmt.Sprint(*v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func mealRecords(meals []db.Meal) [][]string {
+	records := [][]string{{
+		"id", "eaten_at", "meal_type", "dish_name", "text", "calories", "proteins", "fats", "carbohydrates",
+		"health_rating", "aesthetic_rating", "is_spam", "photo_url", "created_at",
+	}}
+
+	for _, m := range meals {
+		var insights db.FoodInsights
+		if m.FoodInsights != nil {
+			insights = *m.FoodInsights
+		}
+
+		records = append(records, []string{
+			strconv.FormatInt(m.ID, 10),
+			m.EatenAt.UTC().Format(time.RFC3339),
+			formatOptional(m.MealType),
+			formatOptional(m.DishName),
+			formatOptional(m.Text),
+			strconv.Itoa(insights.Calories),
+			strconv.Itoa(insights.Proteins),
+			strconv.Itoa(insights.Fats),
+			strconv.Itoa(insights.Carbohydrates),
+			formatOptional(m.HealthRating),
+			formatOptional(m.AestheticRating),
+			strconv.FormatBool(m.IsSpam),
+			m.PhotoURL,
+			m.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	return records
+}
+
+func ingredientRecords(meals []db.Meal) [][]string {
+	records := [][]string{{"meal_id", "name", "weight", "calories", "proteins", "fats", "carbohydrates"}}
+
+	for _, m := range meals {
+		for _, i := range m.Ingredients {
+			records = append(records, []string{
+				strconv.FormatInt(m.ID, 10),
+				i.Name,
+				formatFloat(i.Weight),
+				formatFloat(i.Calories),
+				formatFloat(i.Macros.Proteins),
+				formatFloat(i.Macros.Fats),
+				formatFloat(i.Macros.Carbohydrates),
+			})
+		}
+	}
+
+	return records
+}
+
+func commentRecords(comments []db.Comment) [][]string {
+	records := [][]string{{"id", "meal_id", "text", "created_at"}}
+
+	for _, c := range comments {
+		records = append(records, []string{
+			strconv.FormatInt(c.ID, 10),
+			strconv.FormatInt(c.MealID, 10),
+			c.Text,
+			c.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	return records
+}
+
+// mealExportFlushEvery is how many meals are written between flushes of a
+// streamed meal export.
+const mealExportFlushEvery = 100
+
+var mealExportHeader = []string{
+	"meal_id", "eaten_at", "meal_type", "dish_name", "text", "tags", "health_rating", "aesthetic_rating",
+	"meal_calories", "meal_proteins", "meal_fats", "meal_carbohydrates",
+	"ingredient", "ingredient_weight_g", "ingredient_calories", "ingredient_proteins", "ingredient_fats", "ingredient_carbohydrates",
+}
+
+// MealExportRecord is one line of an NDJSON meal export.
+type MealExportRecord struct {
+	ID              int64            `json:"id"`
+	EatenAt         time.Time        `json:"eaten_at"`
+	MealType        *string          `json:"meal_type"`
+	DishName        *string          `json:"dish_name"`
+	Text            *string          `json:"text"`
+	Tags            []string         `json:"tags"`
+	HealthRating    *int             `json:"health_rating"`
+	AestheticRating *int             `json:"aesthetic_rating"`
+	FoodInsights    *db.FoodInsights `json:"food_insights"`
+	Ingredients     db.Ingredients   `json:"ingredients"`
+}
+
+// mealExportRecords returns the CSV rows of a meal: one per ingredient, with
+// the meal columns repeated, or a single row without ingredient columns.
+func mealExportRecords(m db.Meal, loc *time.Location) [][]string {
+	var insights db.FoodInsights
+	if m.FoodInsights != nil {
+		insights = *m.FoodInsights
+	}
+
+	meal := []string{
+		strconv.FormatInt(m.ID, 10),
+		m.EatenAt.In(loc).Format(time.RFC3339),
+		formatOptional(m.MealType),
+		formatOptional(m.DishName),
+		formatOptional(m.Text),
+		strings.Join(m.Tags, ";"),
+		formatOptional(m.HealthRating),
+		formatOptional(m.AestheticRating),
+		strconv.Itoa(insights.Calories),
+		strconv.Itoa(insights.Proteins),
+		strconv.Itoa(insights.Fats),
+		strconv.Itoa(insights.Carbohydrates),
+	}
+
+	if len(m.Ingredients) == 0 {
+		return [][]string{append(meal, "", "", "", "", "", "")}
+	}
+
+	records := make([][]string, 0, len(m.Ingredients))
+	for _, i := range m.Ingredients {
+		records = append(records, append(append([]string{}, meal...),
+			i.Name,
+			formatFloat(i.Weight),
+			formatFloat(i.Calories),
+			formatFloat(i.Macros.Proteins),
+			formatFloat(i.Macros.Fats),
+			formatFloat(i.Macros.Carbohydrates),
+		))
+	}
+
+	return records
+}
+
+// ExportMeals streams the caller's meals eaten between the "from" and "to"
+// local dates as CSV, one row per ingredient, or as NDJSON, one meal per
+// line, depending on the "format" query parameter.
+func (a *API) ExportMeals(c echo.Context) error {
+	user, err := a.getUser(getUserID(c))
+	if err != nil {
+		return err
+	}
+
+	loc := userLocation(user)
+
+	// Exports are streamed, so any range can be exported.
+	start, end, err := parseDateRange(c, loc, 31, 0)
+	if err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	default:
+		return terrors.BadRequest(fmt.Errorf("unknown format: %s", format), "format must be csv or ndjson")
+	}
+
+	fileName := fmt.Sprintf("meals-%s-%s.%s", start.Format(dateLayout), end.AddDate(0, 0, -1).Format(dateLayout), format)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fileName))
+	res.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(res)
+	enc := json.NewEncoder(res)
+
+	if format == "csv" {
+		if err := cw.Write(mealExportHeader); err != nil {
+			return err
+		}
+	}
+
+	written := 0
+
+	err = a.storage.StreamUserMeals(user.ID, start, end, func(m db.Meal) error {
+		if format == "csv" {
+			if err := cw.WriteAll(mealExportRecords(m, loc)); err != nil {
+				return err
+			}
+		} else {
+			record := MealExportRecord{
+				ID:              m.ID,
+				EatenAt:         m.EatenAt.In(loc),
+				MealType:        m.MealType,
+				DishName:        m.DishName,
+				Text:            m.Text,
+				Tags:            m.Tags,
+				HealthRating:    m.HealthRating,
+				AestheticRating: m.AestheticRating,
+				FoodInsights:    m.FoodInsights,
+				Ingredients:     m.Ingredients,
+			}
+
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+
+		if written++; written%mealExportFlushEvery == 0 {
+			res.Flush()
+		}
+
+		return nil
+	})
+
+	cw.Flush()
+
+	if err != nil {
+		// The status is already sent, so the client sees a truncated file.
+		log.Printf("Failed to export meals of user %d: %v", user.ID, err)
+		return nil
+	}
+
+	return cw.Error()
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/nutrition"
+	"eatsome/internal/terrors"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type GoalValues struct {
+	Calories      int `json:"calories" validate:"min=0,max=20000"`
+	Proteins      int `json:"proteins" validate:"min=0,max=2000"`
+	Fats          int `json:"fats" validate:"min=0,max=2000"`
+	Carbohydrates int `json:"carbohydrates" validate:"min=0,max=3000"`
+}
+
+type WeekdayGoal struct {
+	Weekday int `json:"weekday" validate:"min=0,max=6"`
+	GoalValues
+}
+
+type BodyProfileRequest struct {
+	Age           int     `json:"age" validate:"required,min=14,max=120"`
+	Sex           string  `json:"sex" validate:"required,oneof=male female"`
+	HeightCm      float64 `json:"height_cm" validate:"required,min=100,max=250"`
+	WeightKg      float64 `json:"weight_kg" validate:"required,min=30,max=300"`
+	ActivityLevel string  `json:"activity_level" validate:"required,oneof=sedentary light moderate active very_active"`
+}
+
+// SetGoalsRequest sets explicit goals or, when Profile is given, goals
+// calculated with the Mifflin-St Jeor equation. Weekdays override the
+// default goal on specific days of the week (0 is Sunday).
+type SetGoalsRequest struct {
+	Goals    *GoalValues         `json:"goals"`
+	Profile  *BodyProfileRequest `json:"profile"`
+	Weekdays []WeekdayGoal       `json:"weekdays" validate:"dive"`
+}
+
+type GoalVersionResponse struct {
+	EffectiveFrom string        `json:"effective_from"`
+	Source        string        `json:"source"`
+	Goal          *GoalValues   `json:"goal"`
+	Weekdays      []WeekdayGoal `json:"weekdays"`
+}
+
+type DailyProgressResponse struct {
+	Date      string          `json:"date"`
+	Meals     int             `json:"meals"`
+	Consumed  db.FoodInsights `json:"consumed"`
+	Goal      *GoalValues     `json:"goal"`
+	Remaining *GoalValues     `json:"remaining"`
+}
+
+func goalValues(g db.Goal) GoalValues {
+	return GoalValues{
+		Calories:      g.Calories,
+		Proteins:      g.Proteins,
+		Fats:          g.Fats,
+		Carbohydrates: g.Carbohydrates,
+	}
+}
+
+// goalForDate picks the goal in force on a local date from goals as listed
+// by ListGoals, like GetGoalForDate does in the database.
+func goalForDate(goals []db.Goal, date string, weekday time.Weekday) *db.Goal {
+	var goal *db.Goal
+	var version string
+
+	for i, g := range goals {
+		if g.EffectiveFrom > date {
+			continue
+		}
+
+		if version == "" {
+			version = g.EffectiveFrom
+		} else if g.EffectiveFrom != version {
+			break
+		}
+
+		if g.Weekday == nil {
+			goal = &goals[i]
+		} else if g.Weekday != nil && *g.Weekday == int(weekday) {
+			return &goals[i]
+		}
+	}
+
+	return goal
+}
+
+func (a *API) SetGoals(c echo.Context) error {
+	uid := getUserID(c)
+
+	var req SetGoalsRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	if (req.Goals == nil) == (req.Profile == nil) {
+		return terrors.BadRequest(errors.New("goals or profile required"), "either goals or profile must be set")
+	}
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	source := db.GoalSourceManual
+	values := req.Goals
+
+	if req.Profile != nil {
+		targets, err := nutrition.CalculateTargets(nutrition.Profile{
+			Age:           req.Profile.Age,
+			Sex:           nutrition.Sex(req.Profile.Sex),
+			HeightCm:      req.Profile.HeightCm,
+			WeightKg:      req.Profile.WeightKg,
+			ActivityLevel: nutrition.ActivityLevel(req.Profile.ActivityLevel),
+		})
+
+		if err != nil {
+			return terrors.BadRequest(err, "cannot calculate goals")
+		}
+
+		source = db.GoalSourceCalculated
+		values = &GoalValues{
+			Calories:      targets.Calories,
+			Proteins:      targets.Proteins,
+			Fats:          targets.Fats,
+			Carbohydrates: targets.Carbohydrates,
+		}
+	}
+
+	goals := []db.Goal{{
+		Calories:      values.Calories,
+		Proteins:      values.Proteins,
+		Fats:          values.Fats,
+		Carbohydrates: values.Carbohydrates,
+		Source:        source,
+	}}
+
+	seen := make(map[int]bool)
+	for _, w := range req.Weekdays {
+		if seen[w.Weekday] {
+			return terrors.BadRequest(errors.New("duplicate weekday"), "each weekday can be overridden once")
+		}
+		seen[w.Weekday] = true
+
+		weekday := w.Weekday
+		goals = append(goals, db.Goal{
+			Weekday:       &weekday,
+			Calories:      w.Calories,
+			Proteins:      w.Proteins,
+			Fats:          w.Fats,
+			Carbohydrates: w.Carbohydrates,
+			Source:        db.GoalSourceManual,
+		})
+	}
+
+	// A new version starts today so that earlier days keep being judged
+	// against the goal that was in force at the time.
+	effectiveFrom := time.Now().In(userLocation(user)).Format(dateLayout)
+
+	if err := a.storage.SetGoals(uid, effectiveFrom, goals); err != nil {
+		return terrors.InternalServerError(err, "cannot set goals")
+	}
+
+	return a.ListGoals(c)
+}
+
+func (a *API) ListGoals(c echo.Context) error {
+	uid := getUserID(c)
+
+	goals, err := a.storage.ListGoals(uid)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list goals")
+	}
+
+	resp := make([]GoalVersionResponse, 0)
+
+	for _, g := range goals {
+		if len(resp) == 0 || resp[len(resp)-1].EffectiveFrom != g.EffectiveFrom {
+			resp = append(resp, GoalVersionResponse{
+				EffectiveFrom: g.EffectiveFrom,
+				Source:        g.Source,
+				Weekdays:      make([]WeekdayGoal, 0),
+			})
+		}
+
+		version := &resp[len(resp)-1]
+		values := goalValues(g)
+
+		if g.Weekday == nil {
+			version.Source = g.Source
+			version.Goal = &values
+		} else {
+			version.Weekdays = append(version.Weekdays, WeekdayGoal{Weekday: *g.Weekday, GoalValues: values})
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// dailyProgress returns consumption on the local day starting at day against
+// the goal in force on that day.
+func (a *API) dailyProgress(user *db.User, day time.Time) (*DailyProgressResponse, error) {
+	totals, err := a.storage.GetNutritionTotals(user.ID, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &DailyProgressResponse{
+		Date:     day.Format(dateLayout),
+		Meals:    totals.Meals,
+		Consumed: totals.Totals,
+	}
+
+	goal, err := a.storage.GetGoalForDate(user.ID, resp.Date, day.Weekday())
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return resp, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values := goalValues(*goal)
+	resp.Goal = &values
+	resp.Remaining = &GoalValues{
+		Calories:      goal.Calories - totals.Totals.Calories,
+		Proteins:      goal.Proteins - totals.Totals.Proteins,
+		Fats:          goal.Fats - totals.Totals.Fats,
+		Carbohydrates: goal.Carbohydrates - totals.Totals.Carbohydrates,
+	}
+
+	return resp, nil
+}
+
+func (a *API) GetGoalProgress(c echo.Context) error {
+	uid := getUserID(c)
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	loc := userLocation(user)
+	day := startOfDay(time.Now().In(loc))
+
+	if date := c.QueryParam("date"); date != "" {
+		day, err = time.ParseInLocation(dateLayout, date, loc)
+		if err != nil {
+			return terrors.BadRequest(err, "invalid date")
+		}
+	}
+
+	resp, err := a.dailyProgress(user, day)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot get progress")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
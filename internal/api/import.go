@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"eatsome/internal/db"
+	"eatsome/internal/importer"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxImportSize limits uploaded export files.
+	maxImportSize = 20 << 20
+
+	// importBatchSize is how many meals are stored between progress updates.
+	importBatchSize = 200
+)
+
+// ImportMeals queues an import of a MyFitnessPal or Cronometer CSV export
+// uploaded as the "file" form field. The import runs in the background; its
+// progress is available from GetMealImport.
+func (a *API) ImportMeals(c echo.Context) error {
+	uid := getUserID(c)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return terrors.BadRequest(err, "file is required")
+	}
+
+	if file.Size > maxImportSize {
+		return terrors.BadRequest(fmt.Errorf("file of %d bytes is too large", file.Size), "file is too large")
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return terrors.BadRequest(err, "cannot read file")
+	}
+
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxImportSize))
+	if err != nil {
+		return terrors.BadRequest(err, "cannot read file")
+	}
+
+	source, err := importer.Detect(data)
+	if err != nil {
+		return terrors.BadRequest(err, "file is not a MyFitnessPal or Cronometer export")
+	}
+
+	res, err := a.storage.CreateMealImport(uid, source, data)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot create import")
+	}
+
+	go a.runMealImport(*res)
+
+	return c.JSON(http.StatusAccepted, res)
+}
+
+func (a *API) GetMealImport(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	res, err := a.storage.GetMealImport(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "import not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get import")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// ProcessMealImports runs imports left pending or abandoned, e.g. by a
+// restart. It is called by the scheduler.
+func (a *API) ProcessMealImports(ctx context.Context) error {
+	imports, err := a.storage.ListPendingMealImports()
+	if err != nil {
+		return fmt.Errorf("failed to list imports: %w", err)
+	}
+
+	for _, i := range imports {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		a.runMealImport(i)
+	}
+
+	return nil
+}
+
+func (a *API) runMealImport(i db.MealImport) {
+	data, err := a.storage.ClaimMealImport(i.ID)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return
+	} else if err != nil {
+		log.Printf("Failed to claim import %d: %v", i.ID, err)
+		return
+	}
+
+	err = a.importMeals(i, data)
+	if err != nil {
+		log.Printf("Failed to import meals for import %d: %v", i.ID, err)
+	} else {
+		a.checkAchievements(i.UserID)
+	}
+
+	if finishErr := a.storage.FinishMealImport(i.ID, err); finishErr != nil {
+		log.Printf("Failed to finish import %d: %v", i.ID, finishErr)
+	}
+}
+
+// importMeals stores the meals of an export file in batches, recording
+// progress after each batch. Meals imported before are skipped.
+func (a *API) importMeals(i db.MealImport, data []byte) error {
+	user, err := a.storage.GetUserByID(i.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	parsed, err := importer.Parse(i.Source, data)
+	if err != nil {
+		return err
+	}
+
+	loc := userLocation(user)
+	imported := 0
+
+	for start := 0; start < len(parsed); start += importBatchSize {
+		end := min(start+importBatchSize, len(parsed))
+
+		batch := make([]db.Meal, 0, end-start)
+		for _, p := range parsed[start:end] {
+			meal, err := importedMeal(p, loc)
+			if err != nil {
+				return err
+			}
+
+			batch = append(batch, meal)
+		}
+
+		meals, err := a.storage.ImportMeals(user.ID, batch)
+		if err != nil {
+			return err
+		}
+
+		for _, meal := range meals {
+			a.logChange(userActor(user.ID), db.ChangeCreate, db.EntityMeal, meal.ID, &user.ID, nil, meal)
+		}
+
+		imported += len(meals)
+
+		if err := a.storage.UpdateMealImportProgress(i.ID, len(parsed), end, imported); err != nil {
+			return err
+		}
+	}
+
+	return a.storage.UpdateMealImportProgress(i.ID, len(parsed), len(parsed), imported)
+}
+
+func importedMeal(p importer.Meal, loc *time.Location) (db.Meal, error) {
+	eatenAt, err := p.EatenAt(loc)
+	if err != nil {
+		return db.Meal{}, err
+	}
+
+	key, mealType, insights := p.Key, p.MealType, p.FoodInsights
+
+	meal := db.Meal{
+		EatenAt:      eatenAt,
+		MealType:     &mealType,
+		Ingredients:  p.Ingredients,
+		FoodInsights: &insights,
+		ImportKey:    &key,
+	}
+
+	if p.Note != "" {
+		meal.Text = &p.Note
+	}
+
+	if len(p.Ingredients) > 0 {
+		names := make([]string, 0, len(p.Ingredients))
+		for _, ingredient := range p.Ingredients {
+			names = append(names, ingredient.Name)
+		}
+
+		dishName := strings.Join(names, ", ")
+		meal.DishName = &dishName
+	}
+
+	return meal, nil
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+// AddIngredientRequest adds an ingredient the recognizer missed. Calories
+// and macros are for the given weight.
+type AddIngredientRequest struct {
+	Version  int     `json:"version" validate:"required,min=1"`
+	Name     string  `json:"name" validate:"required"`
+	Weight   float64 `json:"weight" validate:"gt=0,lte=5000"`
+	Calories float64 `json:"calories" validate:"min=0"`
+	Macros   struct {
+		Proteins      float64 `json:"proteins" validate:"min=0"`
+		Fats          float64 `json:"fats" validate:"min=0"`
+		Carbohydrates float64 `json:"carbohydrates" validate:"min=0"`
+	} `json:"macronutrients"`
+}
+
+// UpdateIngredientRequest corrects an ingredient. A new weight rescales its
+// calories and macros proportionally.
+type UpdateIngredientRequest struct {
+	Version int      `json:"version" validate:"required,min=1"`
+	Name    *string  `json:"name" validate:"omitempty,min=1"`
+	Weight  *float64 `json:"weight" validate:"omitempty,gt=0,lte=5000"`
+}
+
+// DeleteIngredientRequest takes the meal version from the query string since
+// DELETE requests have no body.
+type DeleteIngredientRequest struct {
+	Version int `query:"version" validate:"required,min=1"`
+}
+
+// getOwnMeal returns a meal of the caller, or not found for anyone else's.
+func (a *API) getOwnMeal(uid, mealID int64) (*db.Meal, error) {
+	meal, err := a.storage.GetMealByID(mealID)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return nil, terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return nil, terrors.InternalServerError(err, "cannot get meal")
+	}
+
+	if meal.UserID != uid {
+		return nil, terrors.NotFound(db.ErrNotFound, "meal not found")
+	}
+
+	return meal, nil
+}
+
+// editableMeal returns a meal of the caller whose ingredients can be edited
+// at the version the caller last read. Ingredients are addressed by index,
+// so edits of any other version would change the wrong ones, and a running
+// analysis would overwrite them when it finishes.
+func (a *API) editableMeal(uid, mealID int64, version int) (*db.Meal, error) {
+	meal, err := a.getOwnMeal(uid, mealID)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.analysisPending(mealID) {
+		return nil, terrors.Conflict(errors.New("analysis pending"), "meal is being analyzed, try again when it finishes")
+	}
+
+	if meal.Version != version {
+		return nil, terrors.Conflict(db.ErrConflict, "meal was changed, reload it and try again")
+	}
+
+	return meal, nil
+}
+
+// ingredientIndex parses the ":index" path parameter of a meal's ingredient.
+func ingredientIndex(c echo.Context, meal *db.Meal) (int, error) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		return 0, terrors.BadRequest(err, "invalid ingredient index")
+	}
+
+	if index < 0 || index >= len(meal.Ingredients) {
+		return 0, terrors.NotFound(fmt.Errorf("ingredient %d out of range", index), "ingredient not found")
+	}
+
+	return index, nil
+}
+
+// saveIngredients replaces the ingredients of meal, which is as the caller
+// last read it. It fails with a conflict if the meal changed since.
+func (a *API) saveIngredients(c echo.Context, uid int64, meal *db.Meal, ingredients db.Ingredients) error {
+	res, err := a.storage.SetMealIngredients(uid, meal.ID, meal.Version, ingredients)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil && errors.Is(err, db.ErrConflict) {
+		return terrors.Conflict(err, "meal was changed, reload it and try again")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot update ingredients")
+	}
+
+	a.logChange(requestActor(c), db.ChangeUpdate, db.EntityMeal, meal.ID, &uid, meal, res)
+
+	return c.JSON(http.StatusOK, a.withReadURLs(res))
+}
+
+func (a *API) AddIngredient(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req AddIngredientRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	meal, err := a.editableMeal(uid, id, req.Version)
+	if err != nil {
+		return err
+	}
+
+	ingredient := db.Ingredient{
+		Name:     req.Name,
+		Weight:   req.Weight,
+		Calories: req.Calories,
+	}
+	ingredient.Macros.Proteins = req.Macros.Proteins
+	ingredient.Macros.Fats = req.Macros.Fats
+	ingredient.Macros.Carbohydrates = req.Macros.Carbohydrates
+
+	ingredients := append(db.Ingredients{}, meal.Ingredients...)
+	ingredients = append(ingredients, ingredient)
+
+	return a.saveIngredients(c, uid, meal, ingredients)
+}
+
+func (a *API) UpdateIngredient(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req UpdateIngredientRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	meal, err := a.editableMeal(uid, id, req.Version)
+	if err != nil {
+		return err
+	}
+
+	index, err := ingredientIndex(c, meal)
+	if err != nil {
+		return err
+	}
+
+	ingredients := append(db.Ingredients{}, meal.Ingredients...)
+	ingredient := ingredients[index]
+
+	if req.Name != nil {
+		ingredient.Name = *req.Name
+	}
+
+	if req.Weight != nil {
+		ingredient = ingredient.Scaled(*req.Weight)
+	}
+
+	ingredients[index] = ingredient
+
+	return a.saveIngredients(c, uid, meal, ingredients)
+}
+
+func (a *API) DeleteIngredient(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req DeleteIngredientRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	meal, err := a.editableMeal(uid, id, req.Version)
+	if err != nil {
+		return err
+	}
+
+	index, err := ingredientIndex(c, meal)
+	if err != nil {
+		return err
+	}
+
+	ingredients := append(db.Ingredients{}, meal.Ingredients[:index]...)
+	ingredients = append(ingredients, meal.Ingredients[index+1:]...)
+
+	return a.saveIngredients(c, uid, meal, ingredients)
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"time"
+)
+
+// leaderboardSize is how many entries a leaderboard returns.
+const leaderboardSize = 50
+
+type LeaderboardMealResponse struct {
+	ID           int64   `json:"id"`
+	DishName     *string `json:"dish_name"`
+	PhotoURL     string  `json:"photo_url"`
+	ThumbnailURL *string `json:"thumbnail_url"`
+}
+
+type LeaderboardEntryResponse struct {
+	Rank  int                      `json:"rank"`
+	User  UserResponse             `json:"user"`
+	Score float64                  `json:"score"`
+	Meals int                      `json:"meals"`
+	Meal  *LeaderboardMealResponse `json:"meal,omitempty"`
+}
+
+type LeaderboardResponse struct {
+	Board       string                     `json:"board"`
+	Period      string                     `json:"period"`
+	PeriodStart string                     `json:"period_start"`
+	Entries     []LeaderboardEntryResponse `json:"entries"`
+}
+
+// leaderboardPeriod returns the UTC bounds of the week, starting on Monday,
+// or the month that contains t.
+func leaderboardPeriod(period string, t time.Time) (time.Time, time.Time) {
+	day := startOfDay(t.UTC())
+
+	if period == db.LeaderboardMonth {
+		start := day.AddDate(0, 0, 1-day.Day())
+		return start, start.AddDate(0, 1, 0)
+	}
+
+	start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// GetLeaderboard returns the "aesthetic", "health" or "streak" board for the
+// week or month given by "period" that contains "date", by default the
+// current one. Scores are those of the last scheduled refresh.
+func (a *API) GetLeaderboard(c echo.Context) error {
+	uid := getUserID(c)
+
+	board := c.Param("board")
+	switch board {
+	case db.LeaderboardAesthetic, db.LeaderboardHealth, db.LeaderboardStreak:
+	default:
+		return terrors.NotFound(fmt.Errorf("unknown leaderboard %q", board), "leaderboard not found")
+	}
+
+	period := c.QueryParam("period")
+	switch period {
+	case "":
+		period = db.LeaderboardWeek
+	case db.LeaderboardWeek, db.LeaderboardMonth:
+	default:
+		return terrors.BadRequest(fmt.Errorf("unknown period %q", period), "period must be week or month")
+	}
+
+	at := time.Now()
+	if date := c.QueryParam("date"); date != "" {
+		day, err := time.Parse(dateLayout, date)
+		if err != nil {
+			return terrors.BadRequest(err, "invalid date")
+		}
+		at = day
+	}
+
+	start, _ := leaderboardPeriod(period, at)
+
+	entries, err := a.storage.ListLeaderboard(uid, board, period, start, leaderboardSize)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot get leaderboard")
+	}
+
+	resp := LeaderboardResponse{
+		Board:       board,
+		Period:      period,
+		PeriodStart: start.Format(dateLayout),
+		Entries:     make([]LeaderboardEntryResponse, 0, len(entries)),
+	}
+
+	for _, e := range entries {
+		user, err := a.storage.GetUserByID(e.UserID)
+		if err != nil {
+			log.Printf("Failed to get user: %v", err)
+			continue
+		}
+
+		entry := LeaderboardEntryResponse{
+			Rank:  len(resp.Entries) + 1,
+			Score: e.Score,
+			Meals: e.Meals,
+			User: UserResponse{
+				ID:        user.ID,
+				Username:  user.Username,
+				AvatarURL: a.avatarReadURL(user.AvatarURL),
+				FirstName: user.FirstName,
+				LastName:  user.LastName,
+			},
+		}
+
+		if e.Meal != nil {
+			a.withReadURLs(e.Meal)
+			entry.Meal = &LeaderboardMealResponse{
+				ID:           e.Meal.ID,
+				DishName:     e.Meal.DishName,
+				PhotoURL:     e.Meal.PhotoURL,
+				ThumbnailURL: e.Meal.ThumbnailURL,
+			}
+		}
+
+		resp.Entries = append(resp.Entries, entry)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// RefreshLeaderboards recomputes the current and previous week and month,
+// so that late meals and ratings still count once a period has ended. It is
+// called by the scheduler.
+func (a *API) RefreshLeaderboards(ctx context.Context) error {
+	now := time.Now()
+
+	for _, period := range []string{db.LeaderboardWeek, db.LeaderboardMonth} {
+		current, _ := leaderboardPeriod(period, now)
+		previous, _ := leaderboardPeriod(period, current.Add(-time.Hour))
+
+		for _, start := range []time.Time{previous, current} {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			_, end := leaderboardPeriod(period, start)
+
+			if err := a.storage.RefreshLeaderboards(period, start, end); err != nil {
+				return fmt.Errorf("failed to refresh %s leaderboards from %s: %w", period, start.Format(dateLayout), err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,19 +1,25 @@
 package api
 
 import (
+	"context"
 	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
 func getUserID(c echo.Context) int64 {
-	user := c.Get("user").(*jwt.Token)
-	claims := user.Claims.(*JWTClaims)
+	claims, ok := ClaimsFromContext(c.Request().Context())
+	if !ok {
+		return 0
+	}
+
 	return claims.UID
 }
 
@@ -26,31 +32,101 @@ type UserResponse struct {
 }
 
 type MealResponse struct {
-	ID              int64            `json:"id"`
-	UserID          int64            `json:"user_id"`
-	PhotoURL        string           `json:"photo_url"`
-	Text            *string          `json:"text"`
-	DishName        *string          `json:"dish_name"`
-	AestheticRating *int             `json:"aesthetic_rating"`
-	HealthRating    *int             `json:"health_rating"`
-	IsSpam          bool             `json:"is_spam"`
-	FoodInsights    *db.FoodInsights `json:"food_insights"`
-	User            UserResponse     `json:"user"`
-	Ingredients     db.Ingredients   `json:"ingredients"`
-	CreatedAt       time.Time        `json:"created_at"`
-	UpdatedAt       time.Time        `json:"updated_at"`
+	ID              int64             `json:"id"`
+	UserID          int64             `json:"user_id"`
+	PhotoURL        string            `json:"photo_url"`
+	ThumbnailURL    *string           `json:"thumbnail_url"`
+	Text            *string           `json:"text"`
+	DishName        *string           `json:"dish_name"`
+	AestheticRating *int              `json:"aesthetic_rating"`
+	HealthRating    *int              `json:"health_rating"`
+	IsSpam          bool              `json:"is_spam"`
+	RemovedAt       *time.Time        `json:"removed_at"`
+	FoodInsights    *db.FoodInsights  `json:"food_insights"`
+	User            UserResponse      `json:"user"`
+	Ingredients     db.Ingredients    `json:"ingredients"`
+	EatenAt         time.Time         `json:"eaten_at"`
+	MealType        *string           `json:"meal_type"`
+	Visibility      string            `json:"visibility"`
+	Reactions       db.ReactionCounts `json:"reactions"`
+	MyReaction      *string           `json:"my_reaction"`
+	Version         int               `json:"version"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
 }
 
+// GetMeals lists meals eaten between the "from" and "to" dates, which are
+// local dates in the caller's timezone. It defaults to the last month.
 func (a *API) GetMeals(c echo.Context) error {
-	end := time.Now()
-	start := end.AddDate(0, -1, 0)
+	viewer, err := a.getUser(getUserID(c))
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseDateRange(c, userLocation(viewer), 31, maxMealRangeDays)
+	if err != nil {
+		return err
+	}
+
+	meals, err := a.storage.ListMeals(viewer.ID, start, end)
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, a.mealResponses(meals))
+}
+
+// GetMeal returns a meal the caller may see. Meals hidden from the caller
+// are reported as missing.
+func (a *API) GetMeal(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	meal, err := a.storage.GetVisibleMeal(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get meal")
+	}
+
+	resp := a.mealResponses([]db.Meal{*meal})
+	if len(resp) == 0 {
+		return terrors.NotFound(nil, "meal not found")
+	}
 
-	meals, err := a.storage.ListMeals(start, end)
+	return c.JSON(http.StatusOK, resp[0])
+}
+
+// GetUserMeals lists the meals of a user's profile that the caller may see,
+// eaten between the "from" and "to" dates in the caller's timezone.
+func (a *API) GetUserMeals(c echo.Context) error {
+	viewer, err := a.getUser(getUserID(c))
+	if err != nil {
+		return err
+	}
+
+	uid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return terrors.BadRequest(err, "invalid user id")
+	}
 
+	start, end, err := parseDateRange(c, userLocation(viewer), 31, maxMealRangeDays)
 	if err != nil {
 		return err
 	}
 
+	meals, err := a.storage.ListProfileMeals(viewer.ID, uid, start, end)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list meals")
+	}
+
+	return c.JSON(http.StatusOK, a.mealResponses(meals))
+}
+
+// mealResponses adds the authors to meals. Meals whose author cannot be
+// loaded are skipped.
+func (a *API) mealResponses(meals []db.Meal) []MealResponse {
 	var resp []MealResponse
 
 	// fetch user data
@@ -61,41 +137,60 @@ func (a *API) GetMeals(c echo.Context) error {
 			continue
 		}
 
+		a.withReadURLs(&meal)
+
 		resp = append(resp, MealResponse{
 			ID:              meal.ID,
 			UserID:          meal.UserID,
 			PhotoURL:        meal.PhotoURL,
+			ThumbnailURL:    meal.ThumbnailURL,
 			Text:            meal.Text,
 			DishName:        meal.DishName,
 			AestheticRating: meal.AestheticRating,
 			HealthRating:    meal.HealthRating,
 			IsSpam:          meal.IsSpam,
+			RemovedAt:       meal.RemovedAt,
 			FoodInsights:    meal.FoodInsights,
 			Ingredients:     meal.Ingredients,
+			EatenAt:         meal.EatenAt,
+			MealType:        meal.MealType,
+			Visibility:      meal.Visibility,
+			Reactions:       meal.Reactions,
+			MyReaction:      meal.MyReaction,
+			Version:         meal.Version,
 			CreatedAt:       meal.CreatedAt,
 			UpdatedAt:       meal.UpdatedAt,
 			User: UserResponse{
 				ID:        user.ID,
 				Username:  user.Username,
-				AvatarURL: user.AvatarURL,
+				AvatarURL: a.avatarReadURL(user.AvatarURL),
 				FirstName: user.FirstName,
 				LastName:  user.LastName,
 			},
 		})
 	}
 
-	return c.JSON(http.StatusOK, resp)
+	return resp
 }
 
 type CreateMealRequest struct {
-	Photo string  `json:"photo" validate:"required"`
-	Text  *string `json:"text"`
+	Photo      string     `json:"photo" validate:"required"`
+	Text       *string    `json:"text"`
+	EatenAt    *time.Time `json:"eaten_at"`
+	MealType   *string    `json:"meal_type" validate:"omitempty,oneof=breakfast lunch dinner snack"`
+	Visibility *string    `json:"visibility" validate:"omitempty,oneof=public followers private"`
 }
 
+// UpdateMealRequest is a partial update: only fields present in the body are
+// changed. Version must be the version of the meal the client last read.
 type UpdateMealRequest struct {
-	Text  *string `json:"text"`
-	Tags  []int   `json:"tags"`
-	Photo string  `json:"photo" validate:"required"`
+	Version    int        `json:"version" validate:"required,min=1"`
+	Text       *string    `json:"text"`
+	Tags       []int      `json:"tags"`
+	Photo      *string    `json:"photo" validate:"omitempty,min=1"`
+	EatenAt    *time.Time `json:"eaten_at"`
+	MealType   *string    `json:"meal_type" validate:"omitempty,oneof=breakfast lunch dinner snack"`
+	Visibility *string    `json:"visibility" validate:"omitempty,oneof=public followers private"`
 }
 
 func (a *API) CreateMeal(c echo.Context) error {
@@ -110,71 +205,189 @@ func (a *API) CreateMeal(c echo.Context) error {
 		return err
 	}
 
-	meal := db.Meal{
-		PhotoURL: fmt.Sprintf("%s/%s", a.cfg.AssetsURL, req.Photo),
-		Text:     req.Text,
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
 	}
 
-	res, err := a.storage.AddMeal(uid, meal)
-
+	photo, err := a.confirmedPhoto(uid, req.Photo)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		user, err := a.storage.GetUserByID(uid)
-		if err != nil {
-			log.Printf("Failed to get user: %v", err)
-			return
-		}
+	eatenAt := time.Now()
+	if req.EatenAt != nil {
+		eatenAt = *req.EatenAt
+	}
 
-		lang := "en"
+	res, err := a.addMeal(requestActor(c), user, photo, req.Text, eatenAt, req.MealType, req.Visibility)
+	if err != nil && errors.Is(err, db.ErrPhotoInUse) {
+		return terrors.Conflict(err, "photo is already used by another meal")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot create meal")
+	}
 
-		if user.LanguageCode != nil && *user.LanguageCode == "ru" {
-			lang = "ru"
-		}
+	// The meal counts as being analyzed from now on, not only once the
+	// goroutine gets to it.
+	a.beginAnalysis(res.ID)
+
+	go func() {
+		defer a.endAnalysis(res.ID)
 
-		if _, err := a.runAISuggestions(lang, uid, res.ID); err != nil {
+		if _, err := a.analyzeMeal(uid, res.ID); err != nil {
 			log.Printf("Failed to run AI suggestions: %v", err)
 		}
 	}()
 
-	return c.JSON(http.StatusCreated, res)
+	return c.JSON(http.StatusCreated, a.withReadURLs(res))
 }
 
-func (a *API) runAISuggestions(lang string, uid, mealID int64) (*db.Meal, error) {
-	meal, err := a.storage.GetMealByID(mealID)
+// inferMealType guesses the meal type from the local time it was eaten at.
+func inferMealType(local time.Time) string {
+	switch hour := local.Hour(); {
+	case hour >= 4 && hour < 11:
+		return db.MealTypeBreakfast
+	case hour >= 11 && hour < 16:
+		return db.MealTypeLunch
+	case hour >= 16 && hour < 22:
+		return db.MealTypeDinner
+	default:
+		return db.MealTypeSnack
+	}
+}
+
+// addMeal stores a meal for a confirmed photo upload. Without a meal type one is
+// inferred from the time the meal was eaten in the user's timezone, and
+// without a visibility the user's default is used. Recognition is started separately
+// with analyzeMeal.
+func (a *API) addMeal(actor db.Actor, user *db.User, photo *db.PhotoUpload, text *string, eatenAt time.Time, mealType, visibility *string) (*db.Meal, error) {
+	if mealType == nil {
+		inferred := inferMealType(eatenAt.In(userLocation(user)))
+		mealType = &inferred
+	}
 
+	thumbnailURL := a.assetURL(photo.ThumbnailKey)
+
+	meal := db.Meal{
+		PhotoURL:     a.assetURL(photo.ObjectKey),
+		ThumbnailURL: &thumbnailURL,
+		Text:         text,
+		EatenAt:      eatenAt,
+		MealType:     mealType,
+	}
+
+	if visibility != nil {
+		meal.Visibility = *visibility
+	}
+
+	res, err := a.storage.AddMeal(user.ID, meal, photo.ObjectKey)
 	if err != nil {
 		return nil, err
 	}
 
-	info, err := a.recognizer.GetFoodPictureInfo(lang, meal.PhotoURL, meal.Text)
+	a.logChange(actor, db.ChangeCreate, db.EntityMeal, res.ID, &user.ID, nil, res)
+
+	return res, nil
+}
+
+func userLanguage(user *db.User) string {
+	if user.LanguageCode != nil && *user.LanguageCode == "ru" {
+		return "ru"
+	}
+
+	return "en"
+}
+
+func (a *API) analyzeMeal(uid, mealID int64) (*db.Meal, error) {
+	user, err := a.storage.GetUserByID(uid)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	meal.IsSpam = info.IsSpam
-	meal.DishName = &info.DishName
-	meal.AestheticRating = &info.AestheticRating
-	meal.HealthRating = &info.HealthRating
+	meal, err := a.runAISuggestions(systemActor, userLanguage(user), uid, mealID, false)
 
-	meal.FoodInsights = &db.FoodInsights{
-		Calories:      info.Calories,
-		Proteins:      info.Proteins,
-		Fats:          info.Fats,
-		Carbohydrates: info.Carbohydrates,
+	a.notifyMealAnalysis(user, mealID, meal, err)
+
+	if err == nil {
+		a.checkAchievements(uid)
+	}
+
+	return meal, err
+}
+
+// beginAnalysis marks a meal as being recognized until endAnalysis.
+func (a *API) beginAnalysis(mealID int64) {
+	a.analysesMu.Lock()
+	defer a.analysesMu.Unlock()
+
+	if a.analyses == nil {
+		a.analyses = map[int64]int{}
 	}
 
-	meal.Ingredients = info.IngredientsInfo
+	a.analyses[mealID]++
+}
+
+func (a *API) endAnalysis(mealID int64) {
+	a.analysesMu.Lock()
+	defer a.analysesMu.Unlock()
+
+	if a.analyses[mealID]--; a.analyses[mealID] <= 0 {
+		delete(a.analyses, mealID)
+	}
+}
+
+// analysisPending reports whether a meal is being recognized.
+func (a *API) analysisPending(mealID int64) bool {
+	a.analysesMu.Lock()
+	defer a.analysesMu.Unlock()
+
+	return a.analyses[mealID] > 0
+}
+
+// runAISuggestions recognizes a meal's photo and saves the analysis, which
+// is logged as made by actor. Ingredients the owner corrected are only
+// replaced with replaceEdits.
+func (a *API) runAISuggestions(actor db.Actor, lang string, uid, mealID int64, replaceEdits bool) (*db.Meal, error) {
+	a.beginAnalysis(mealID)
+	defer a.endAnalysis(mealID)
+
+	meal, err := a.storage.GetMealByID(mealID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := a.recognizer.GetFoodPictureInfo(lang, a.readURL(meal.PhotoURL), meal.Text)
+	if err != nil {
+		if recordErr := a.storage.AddRecognitionFailure(uid, mealID, err.Error()); recordErr != nil {
+			log.Printf("Failed to record recognition failure of meal %d: %v", mealID, recordErr)
+		}
+		return nil, err
+	}
 
-	res, err := a.storage.UpdateMeal(uid, mealID, *meal, nil)
+	analysis := db.MealAnalysis{
+		DishName:        info.DishName,
+		IsSpam:          info.IsSpam,
+		AestheticRating: info.AestheticRating,
+		HealthRating:    info.HealthRating,
+		Ingredients:     info.IngredientsInfo,
+		FoodInsights: db.FoodInsights{
+			Calories:      info.Calories,
+			Proteins:      info.Proteins,
+			Fats:          info.Fats,
+			Carbohydrates: info.Carbohydrates,
+		},
+		ReplaceEdits: replaceEdits,
+	}
 
+	res, err := a.storage.SaveMealAnalysis(uid, mealID, analysis)
 	if err != nil {
 		return nil, err
 	}
 
-	return res, err
+	a.logChange(actor, db.ChangeAnalyze, db.EntityMeal, mealID, &meal.UserID, meal, res)
+
+	return res, nil
 }
 
 func (a *API) UpdateMeal(c echo.Context) error {
@@ -183,23 +396,212 @@ func (a *API) UpdateMeal(c echo.Context) error {
 
 	var req UpdateMealRequest
 	if err := c.Bind(&req); err != nil {
-		return err
+		return terrors.BadRequest(err, "failed to bind request")
 	}
 
 	if err := c.Validate(req); err != nil {
 		return err
 	}
 
-	meal := db.Meal{
-		Text:     req.Text,
-		PhotoURL: fmt.Sprintf("%s/%s", a.cfg.AssetsURL, req.Photo),
+	update := db.MealUpdate{
+		Text:       req.Text,
+		EatenAt:    req.EatenAt,
+		MealType:   req.MealType,
+		Visibility: req.Visibility,
+		Tags:       req.Tags,
+	}
+
+	before, err := a.getOwnMeal(uid, id)
+	if err != nil {
+		return err
+	}
+
+	// The update below only succeeds if the meal is still at req.Version,
+	// so when before is at it too, before is the meal the update changes.
+	if before.Version != req.Version {
+		return terrors.Conflict(db.ErrConflict, "meal was changed, reload it and try again")
+	}
+
+	if req.Photo != nil {
+		photo, err := a.confirmedPhoto(uid, *req.Photo)
+		if err != nil {
+			return err
+		}
+
+		photoURL, thumbnailURL := a.assetURL(photo.ObjectKey), a.assetURL(photo.ThumbnailKey)
+		update.PhotoURL, update.ThumbnailURL, update.PhotoKey = &photoURL, &thumbnailURL, photo.ObjectKey
+
+		update.ReplacedObjects, err = a.replacedPhotos(before, photoURL, thumbnailURL)
+		if err != nil {
+			return terrors.InternalServerError(err, "cannot check photo use")
+		}
+	}
+
+	res, err := a.storage.UpdateMeal(uid, id, req.Version, update)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil && errors.Is(err, db.ErrConflict) {
+		return terrors.Conflict(err, "meal was changed, reload it and try again")
+	} else if err != nil && errors.Is(err, db.ErrPhotoInUse) {
+		return terrors.Conflict(err, "photo is already used by another meal")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot update meal")
 	}
 
-	res, err := a.storage.UpdateMeal(uid, id, meal, req.Tags)
+	a.logChange(requestActor(c), db.ChangeUpdate, db.EntityMeal, id, &uid, before, res)
 
+	return c.JSON(http.StatusOK, a.withReadURLs(res))
+}
+
+// assetURL returns the public URL of a bucket key.
+func (a *API) assetURL(key string) string {
+	return fmt.Sprintf("%s/%s", a.cfg.AssetsURL, key)
+}
+
+// objectKey returns the bucket key of an asset URL. It returns false for
+// URLs that do not point into the bucket.
+func (a *API) objectKey(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, a.cfg.AssetsURL+"/")
+	return key, ok && key != ""
+}
+
+// mealUndoWindow is how long a deleted meal can be restored before it is
+// purged together with its photo.
+const mealUndoWindow = 10 * time.Minute
+
+type DeleteMealResponse struct {
+	ID        int64     `json:"id"`
+	UndoUntil time.Time `json:"undo_until"`
+}
+
+func (a *API) DeleteMeal(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	before, err := a.getOwnMeal(uid, id)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusCreated, res)
+	deletedAt, err := a.storage.SoftDeleteMeal(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot delete meal")
+	}
+
+	a.logChange(requestActor(c), db.ChangeDelete, db.EntityMeal, id, &uid, before, nil)
+
+	return c.JSON(http.StatusOK, DeleteMealResponse{
+		ID:        id,
+		UndoUntil: deletedAt.Add(mealUndoWindow),
+	})
+}
+
+func (a *API) RestoreMeal(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	res, err := a.storage.RestoreMeal(uid, id, time.Now().Add(-mealUndoWindow))
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found or undo window expired")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot restore meal")
+	}
+
+	a.logChange(requestActor(c), db.ChangeRestore, db.EntityMeal, id, &uid, nil, res)
+
+	return c.JSON(http.StatusOK, a.withReadURLs(res))
+}
+
+// PurgeDeletedMeals permanently deletes meals whose undo window has passed,
+// removing their photos from the bucket first. A meal whose photo cannot be
+// removed is kept and retried on the next run. It is called by the scheduler.
+func (a *API) PurgeDeletedMeals(ctx context.Context) error {
+	meals, err := a.storage.ListDeletedMeals(time.Now().Add(-mealUndoWindow))
+	if err != nil {
+		return fmt.Errorf("failed to list deleted meals: %w", err)
+	}
+
+	for _, meal := range meals {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := a.deleteMealPhotos(ctx, meal); err != nil {
+			log.Printf("Failed to delete photos of meal %d, will retry: %v", meal.ID, err)
+			continue
+		}
+
+		if err := a.storage.DeleteMeal(meal.ID); err != nil {
+			if !errors.Is(err, db.ErrNotFound) {
+				log.Printf("Failed to purge meal %d: %v", meal.ID, err)
+			}
+			continue
+		}
+
+		a.logChange(systemActor, db.ChangePurge, db.EntityMeal, meal.ID, &meal.UserID, meal, nil)
+	}
+
+	return nil
+}
+
+// replacedPhotos returns the objects of a meal's photo and thumbnail that
+// are no longer used once they are replaced by photoURL and thumbnailURL.
+func (a *API) replacedPhotos(meal *db.Meal, photoURL, thumbnailURL string) ([]db.ObjectDeletion, error) {
+	var objects []db.ObjectDeletion
+
+	for _, url := range mealPhotoURLs(*meal) {
+		if url == photoURL || url == thumbnailURL {
+			continue
+		}
+
+		key, ok := a.objectKey(url)
+		if !ok {
+			continue
+		}
+
+		inUse, err := a.storage.PhotoInUse(meal.ID, url)
+		if err != nil {
+			return nil, err
+		}
+
+		if !inUse {
+			objects = append(objects, db.ObjectDeletion{Key: key})
+		}
+	}
+
+	return objects, nil
+}
+
+func mealPhotoURLs(meal db.Meal) []string {
+	urls := []string{meal.PhotoURL}
+	if meal.ThumbnailURL != nil {
+		urls = append(urls, *meal.ThumbnailURL)
+	}
+
+	return urls
+}
+
+// deleteMealPhotos removes a meal's photo and thumbnail from the bucket
+// unless another meal still shows them.
+func (a *API) deleteMealPhotos(ctx context.Context, meal db.Meal) error {
+	for _, url := range mealPhotoURLs(meal) {
+		inUse, err := a.storage.PhotoInUse(meal.ID, url)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			continue
+		}
+
+		if key, ok := a.objectKey(url); ok {
+			if err := a.blobs.DeleteFile(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
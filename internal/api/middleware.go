@@ -2,76 +2,169 @@ package api
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
-	"log"
+	"github.com/labstack/echo/v4"
 	"net/http"
+	"slices"
 	"strings"
 )
 
-const userIDContextKey = "user_id"
+type contextKey int
 
-var httpErrors = map[int]string{
-	http.StatusNotFound:            "Resource not found",
-	http.StatusInternalServerError: "Internal server error",
-	http.StatusBadRequest:          "Bad request",
-	http.StatusUnauthorized:        "Unauthorized access",
+const claimsContextKey contextKey = iota
+
+var (
+	ErrMissingToken = errors.New("missing auth token")
+	ErrInvalidToken = errors.New("invalid auth token")
+	ErrStaleToken   = errors.New("auth token role is outdated")
+	ErrBanned       = errors.New("user is banned")
+	ErrForbidden    = errors.New("role not allowed")
+	ErrImpersonator = errors.New("impersonating admin is no longer allowed")
+)
+
+// WithClaims returns a copy of ctx carrying the authenticated caller.
+func WithClaims(ctx context.Context, claims *JWTClaims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
 }
 
-type ErrorResponse struct {
-	Message string `json:"message"`
+// ClaimsFromContext returns the authenticated caller stored by the auth middleware.
+func ClaimsFromContext(ctx context.Context) (*JWTClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*JWTClaims)
+	if !ok || claims == nil || claims.UID <= 0 {
+		return nil, false
+	}
+
+	return claims, true
 }
 
-func ErrorResponder(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+// ParseToken validates a signed token and returns its claims. Tokens without
+// an expiry or a user ID are rejected.
+func ParseToken(secret, tokenString string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+
 	if err != nil {
-		log.Printf("Error: %s, Path: %s", err.Error(), r.URL.Path)
+		return nil, errors.Join(ErrInvalidToken, err)
 	}
 
-	message, exists := httpErrors[statusCode]
-	if !exists {
-		message = "An error occurred"
+	if !token.Valid || claims.UID <= 0 {
+		return nil, ErrInvalidToken
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	_ = json.NewEncoder(w).Encode(ErrorResponse{Message: message})
+	return claims, nil
 }
 
-func AuthMiddleware(secretKey string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				ErrorResponder(w, r, http.StatusUnauthorized, fmt.Errorf("missing auth header"))
-			}
+func tokenFromRequest(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrMissingToken
+	}
 
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				ErrorResponder(w, r, http.StatusUnauthorized, fmt.Errorf("invalid auth header"))
-			}
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") || parts[1] == "" {
+		return "", ErrInvalidToken
+	}
 
-			tokenString := parts[1]
+	return parts[1], nil
+}
 
-			token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return []byte(secretKey), nil
-			})
+func authenticate(secret string, r *http.Request) (*JWTClaims, error) {
+	tokenString, err := tokenFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
 
-			if err != nil || !token.Valid {
-				ErrorResponder(w, r, http.StatusUnauthorized, err)
+	return ParseToken(secret, tokenString)
+}
+
+// AuthMiddleware rejects requests without a valid bearer token and stores the
+// caller's claims in the request context.
+func AuthMiddleware(secret string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := authenticate(secret, c.Request())
+			if err != nil {
+				return terrors.Unauthorized(err, "auth is invalid")
 			}
 
-			claims, ok := token.Claims.(*JWTClaims)
-			if !ok {
-				ErrorResponder(w, r, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			c.SetRequest(c.Request().WithContext(WithClaims(c.Request().Context(), claims)))
+
+			return next(c)
+		}
+	}
+}
+
+// UserMiddleware rejects requests of banned users and tokens issued for a
+// role the user no longer has, so that role changes apply right away.
+// Impersonation tokens stop working once the admin who issued them is banned
+// or loses the admin role. Requests made while impersonating a user are
+// written to the audit log. It must run after AuthMiddleware.
+func (a *API) UserMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, _ := ClaimsFromContext(c.Request().Context())
+
+		user, err := a.getUser(claims.UID)
+		if err != nil {
+			return err
+		}
+
+		if user.BannedAt != nil {
+			return terrors.Forbidden(ErrBanned, "user is banned")
+		}
+
+		// Tokens issued before roles existed have none and belong to
+		// regular users.
+		if claims.Role != user.Role && !(claims.Role == "" && user.Role == db.RoleUser) {
+			return terrors.Unauthorized(ErrStaleToken, "auth is outdated")
+		}
+
+		if claims.ImpersonatorID != 0 {
+			if err := a.checkImpersonator(claims.ImpersonatorID); err != nil {
+				return err
 			}
 
-			if claims.UID <= 0 {
-				ErrorResponder(w, r, http.StatusUnauthorized, fmt.Errorf("invalid user ID"))
+			a.audit(c, auditImpersonatedRequest, &user.ID, nil, c.Request().Method+" "+c.Request().URL.Path)
+		}
+
+		return next(c)
+	}
+}
+
+// checkImpersonator fails unless the user who issued an impersonation token
+// is still an admin and not banned.
+func (a *API) checkImpersonator(id int64) error {
+	impersonator, err := a.storage.GetUserByID(id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.Unauthorized(errors.Join(ErrImpersonator, err), "auth is outdated")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get user")
+	}
+
+	if impersonator.BannedAt != nil || impersonator.Role != db.RoleAdmin {
+		return terrors.Unauthorized(ErrImpersonator, "auth is outdated")
+	}
+
+	return nil
+}
+
+// RequireRole only lets users with one of the roles through. It must run
+// after UserMiddleware, which checks the role in the token is current.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, _ := ClaimsFromContext(c.Request().Context())
+
+			if !slices.Contains(roles, claims.Role) {
+				return terrors.Forbidden(ErrForbidden, "not allowed")
 			}
 
-			ctx := context.WithValue(r.Context(), userIDContextKey, claims.UID)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
+			return next(c)
+		}
 	}
 }
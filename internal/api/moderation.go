@@ -0,0 +1,184 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// moderationQueueSize is how many open reports the queue returns at once.
+const moderationQueueSize = 100
+
+// moderationActions maps the actions in moderation URLs to the status they
+// resolve reports with.
+var moderationActions = map[string]string{
+	"approve": db.ReportStatusApproved,
+	"remove":  db.ReportStatusRemoved,
+	"ban":     db.ReportStatusBanned,
+}
+
+// moderationAudits maps the status reports are resolved with to the action
+// recorded in the audit log.
+var moderationAudits = map[string]string{
+	db.ReportStatusApproved: auditApproveReport,
+	db.ReportStatusRemoved:  auditRemoveReport,
+	db.ReportStatusBanned:   auditBan,
+}
+
+// ModerationItemResponse is an open report with the reported content, which
+// is nil if it has been deleted since.
+type ModerationItemResponse struct {
+	Report  db.Report     `json:"report"`
+	Author  *UserResponse `json:"author"`
+	Meal    *db.Meal      `json:"meal"`
+	Comment *db.Comment   `json:"comment"`
+}
+
+// ListModerationQueue returns open reports and appeals, oldest first.
+func (a *API) ListModerationQueue(c echo.Context) error {
+	reports, err := a.storage.ListOpenReports(moderationQueueSize)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot list reports")
+	}
+
+	resp := make([]ModerationItemResponse, 0, len(reports))
+
+	for _, r := range reports {
+		item := ModerationItemResponse{Report: r}
+
+		var authorID int64
+
+		if r.CommentID != nil {
+			comment, err := a.storage.GetComment(*r.CommentID)
+			if err != nil && !errors.Is(err, db.ErrNotFound) {
+				return terrors.InternalServerError(err, "cannot get comment")
+			}
+
+			if comment != nil {
+				item.Comment = comment
+				authorID = comment.UserID
+			}
+		} else {
+			meal, err := a.storage.GetMealByID(*r.MealID)
+			if err != nil && !errors.Is(err, db.ErrNotFound) {
+				return terrors.InternalServerError(err, "cannot get meal")
+			}
+
+			if meal != nil {
+				item.Meal = a.withReadURLs(meal)
+				authorID = meal.UserID
+			}
+		}
+
+		if authorID != 0 {
+			if user, err := a.storage.GetUserByID(authorID); err != nil {
+				log.Printf("Failed to get user: %v", err)
+			} else {
+				item.Author = &UserResponse{
+					ID:        user.ID,
+					Username:  user.Username,
+					AvatarURL: a.avatarReadURL(user.AvatarURL),
+					FirstName: user.FirstName,
+					LastName:  user.LastName,
+				}
+			}
+		}
+
+		resp = append(resp, item)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// ModerateReport approves, removes or bans the author of the reported
+// content, resolving every open report of it.
+func (a *API) ModerateReport(c echo.Context) error {
+	uid := getUserID(c)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return terrors.BadRequest(err, "invalid report id")
+	}
+
+	action := c.Param("action")
+
+	status, ok := moderationActions[action]
+	if !ok {
+		return terrors.NotFound(fmt.Errorf("unknown moderation action %q", action), "action not found")
+	}
+
+	report, err := a.storage.GetReport(id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "open report not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot get report")
+	}
+
+	entity, contentID, ownerID, before := a.reportedContent(report)
+
+	var author *db.User
+	if before != nil {
+		author, err = a.storage.GetUserByID(ownerID)
+		if err != nil {
+			return terrors.InternalServerError(err, "cannot get author")
+		}
+	}
+
+	// Moderators can only ban regular users, staff are banned by admins.
+	claims, _ := ClaimsFromContext(c.Request().Context())
+	if status == db.ReportStatusBanned && author != nil && author.Role != db.RoleUser && claims.Role != db.RoleAdmin {
+		return terrors.Forbidden(fmt.Errorf("user %d cannot ban %s %d", uid, author.Role, author.ID), "only admins can ban staff")
+	}
+
+	report, err = a.storage.ResolveReport(uid, id, status)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "open report not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot resolve report")
+	}
+
+	if before != nil {
+		_, _, _, after := a.reportedContent(report)
+		a.logChange(staffActor(c), db.ChangeUpdate, entity, contentID, &ownerID, before, after)
+	}
+
+	var targetUserID *int64
+	if author != nil {
+		targetUserID = &author.ID
+	}
+
+	a.audit(c, moderationAudits[status], targetUserID, &report.ID, fmt.Sprintf("%s %d", entity, contentID))
+
+	if author != nil && status == db.ReportStatusBanned {
+		if banned, err := a.storage.GetUserByID(author.ID); err == nil {
+			a.logChange(staffActor(c), db.ChangeUpdate, db.EntityUser, author.ID, &author.ID, userSnapshot(author), userSnapshot(banned))
+		}
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// reportedContent returns the kind, ID and author of the content a report
+// is about, and the content, which is nil if it is gone.
+func (a *API) reportedContent(r *db.Report) (string, int64, int64, interface{}) {
+	if r.CommentID != nil {
+		comment, err := a.storage.GetComment(*r.CommentID)
+		if err != nil {
+			return db.EntityComment, *r.CommentID, 0, nil
+		}
+
+		return db.EntityComment, comment.ID, comment.UserID, comment
+	}
+
+	meal, err := a.storage.GetMealByID(*r.MealID)
+	if err != nil {
+		return db.EntityMeal, *r.MealID, 0, nil
+	}
+
+	return db.EntityMeal, meal.ID, meal.UserID, meal
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"fmt"
+	"log"
+)
+
+// notifyMealAnalysis tells the owner that recognition of their meal finished.
+// analysisErr is the error returned by runAISuggestions, if any.
+func (a *API) notifyMealAnalysis(user *db.User, mealID int64, meal *db.Meal, analysisErr error) {
+	if !user.NotificationsEnabled {
+		return
+	}
+
+	content := getBotContent(userLanguage(user))
+
+	text := content.AnalysisFailed
+	if analysisErr == nil {
+		text = fmt.Sprintf("%s\n\n%s", content.Analyzed, mealResultText(content, meal))
+	}
+
+	if err := a.sendBotMessage(user.ChatID, text, content.OpenMeal, a.mealLink(mealID)); err != nil {
+		log.Printf("Failed to notify user %d about meal %d: %v", user.ID, mealID, err)
+	}
+}
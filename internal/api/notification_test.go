@@ -0,0 +1,144 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/telegram"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newBotStub returns a bot client talking to a local Bot API stub and the
+// messages sent through it.
+func newBotStub(t *testing.T) (*telegram.Client, func() []telegram.SendMessageRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var sent []telegram.SendMessageRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req telegram.SendMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		sent = append(sent, req)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return telegram.New("123:secret", srv.URL), func() []telegram.SendMessageRequest {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]telegram.SendMessageRequest(nil), sent...)
+	}
+}
+
+var en, ru = "en", "ru"
+
+func notifiedMeal() *db.Meal {
+	dishName := "Pasta"
+
+	return &db.Meal{
+		ID:       7,
+		DishName: &dishName,
+		FoodInsights: &db.FoodInsights{
+			Calories:      650,
+			Proteins:      25,
+			Fats:          20,
+			Carbohydrates: 90,
+		},
+	}
+}
+
+func TestNotifyMealAnalysis(t *testing.T) {
+	bot, sent := newBotStub(t)
+	a := &API{bot: bot, cfg: Config{WebAppURL: "https://app.example"}}
+
+	user := &db.User{ID: 1, ChatID: 42, LanguageCode: &en, NotificationsEnabled: true}
+
+	a.notifyMealAnalysis(user, 7, notifiedMeal(), nil)
+
+	msgs := sent()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+
+	msg := msgs[0]
+	if msg.ChatID != 42 {
+		t.Errorf("ChatID = %d, want 42", msg.ChatID)
+	}
+
+	want := "Your meal is analyzed\n\nPasta\n650 kcal · P 25 g · F 20 g · C 90 g"
+	if msg.Text != want {
+		t.Errorf("Text = %q, want %q", msg.Text, want)
+	}
+
+	if msg.ReplyMarkup == nil || msg.ReplyMarkup.InlineKeyboard[0][0].URL != "https://app.example?startapp=meal_7" {
+		t.Errorf("ReplyMarkup = %+v, want a link to the meal", msg.ReplyMarkup)
+	}
+}
+
+func TestNotifyMealAnalysisFailure(t *testing.T) {
+	bot, sent := newBotStub(t)
+	a := &API{bot: bot, cfg: Config{WebAppURL: "https://app.example"}}
+
+	user := &db.User{ID: 1, ChatID: 42, LanguageCode: &ru, NotificationsEnabled: true}
+
+	a.notifyMealAnalysis(user, 7, nil, errors.New("recognition failed"))
+
+	msgs := sent()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+
+	if want := getBotContent("ru").AnalysisFailed; msgs[0].Text != want {
+		t.Errorf("Text = %q, want %q", msgs[0].Text, want)
+	}
+}
+
+func TestNotifyMealAnalysisSpam(t *testing.T) {
+	bot, sent := newBotStub(t)
+	a := &API{bot: bot}
+
+	user := &db.User{ID: 1, ChatID: 42, LanguageCode: &en, NotificationsEnabled: true}
+
+	a.notifyMealAnalysis(user, 7, &db.Meal{ID: 7, IsSpam: true}, nil)
+
+	msgs := sent()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+
+	if !strings.HasSuffix(msgs[0].Text, getBotContent("en").Spam) {
+		t.Errorf("Text = %q, want the spam message", msgs[0].Text)
+	}
+
+	if msgs[0].ReplyMarkup != nil {
+		t.Errorf("ReplyMarkup = %+v, want none without a Mini App URL", msgs[0].ReplyMarkup)
+	}
+}
+
+func TestNotifyMealAnalysisDisabled(t *testing.T) {
+	bot, sent := newBotStub(t)
+	a := &API{bot: bot}
+
+	user := &db.User{ID: 1, ChatID: 42, LanguageCode: &en, NotificationsEnabled: false}
+
+	a.notifyMealAnalysis(user, 7, notifiedMeal(), nil)
+	a.notifyMealAnalysis(user, 7, nil, errors.New("recognition failed"))
+
+	if msgs := sent(); len(msgs) != 0 {
+		t.Errorf("sent %d messages with notifications disabled", len(msgs))
+	}
+}
@@ -1,11 +1,17 @@
 package api
 
 import (
+	"context"
+	"eatsome/internal/db"
+	"eatsome/internal/imaging"
 	"eatsome/internal/terrors"
+	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"log"
 	"math/rand"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,21 +26,45 @@ func randomString(n int) string {
 	return string(b)
 }
 
-type PresignedURLRequest struct {
+// maxPresignedFiles limits how many uploads can be presigned at once.
+const maxPresignedFiles = 10
+
+// presignedURLTTL is how long a presigned upload URL can be used.
+const presignedURLTTL = 15 * time.Minute
+
+type PresignedFile struct {
 	FileName string `json:"file_name" validate:"required"`
+	Size     int64  `json:"size" validate:"required,min=1"`
+}
+
+type PresignedURLRequest struct {
+	Files []PresignedFile `json:"files" validate:"required,min=1,dive"`
+}
+
+// PresignedUpload is a URL to PUT one file to. Headers must be sent with
+// the request unchanged, and the body must be exactly the requested size.
+type PresignedUpload struct {
+	URL       string            `json:"url"`
+	FileName  string            `json:"file_name"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
 }
 
 type PresignedURLResponse struct {
-	URL      string `json:"url"`
-	FileName string `json:"file_name"`
+	Files []PresignedUpload `json:"files"`
 }
 
+// extFromFileName returns the extension of a photo to upload. Only formats
+// imaging.Decode can read are accepted, since uploads are decoded and
+// stored as JPEG on confirmation.
 func extFromFileName(fileName string) (string, error) {
 	allowed := map[string]bool{
 		".jpg":  true,
 		".jpeg": true,
 		".png":  true,
 		".gif":  true,
+		".webp": true,
+		".heic": true,
 	}
 	ext := strings.ToLower(filepath.Ext(fileName))
 	if !allowed[ext] {
@@ -43,6 +73,9 @@ func extFromFileName(fileName string) (string, error) {
 	return ext, nil
 }
 
+// GetPresignedURL presigns uploads of one or more photos. Each URL only
+// accepts a file of the declared size and of the content type matching its
+// extension.
 func (a *API) GetPresignedURL(c echo.Context) error {
 	var req PresignedURLRequest
 	if err := c.Bind(&req); err != nil {
@@ -59,24 +92,183 @@ func (a *API) GetPresignedURL(c echo.Context) error {
 		return terrors.Unauthorized(nil, "unauthorized")
 	}
 
-	fileExt, err := extFromFileName(req.FileName)
+	if len(req.Files) > maxPresignedFiles {
+		return terrors.BadRequest(fmt.Errorf("%d files requested", len(req.Files)), fmt.Sprintf("at most %d files can be uploaded at once", maxPresignedFiles))
+	}
+
+	res := PresignedURLResponse{Files: make([]PresignedUpload, 0, len(req.Files))}
+	expiresAt := time.Now().Add(presignedURLTTL).UTC().Truncate(time.Second)
+
+	for _, file := range req.Files {
+		fileExt, err := extFromFileName(file.FileName)
+		if err != nil {
+			return terrors.BadRequest(err, "invalid file extension")
+		}
+
+		if file.Size > maxPhotoSize {
+			return terrors.BadRequest(fmt.Errorf("photo of %d bytes is too large", file.Size), "photo is too large")
+		}
+
+		fileName := fmt.Sprintf("%d/%s/%s", uid, time.Now().Format("2006-01-02"), randomString(10)+fileExt)
+
+		upload, err := a.blobs.GetPresignedURL(fileName, file.Size, presignedURLTTL)
+		if err != nil {
+			return terrors.InternalServerError(err, "failed to get presigned url")
+		}
+
+		res.Files = append(res.Files, PresignedUpload{
+			URL:       upload.URL,
+			FileName:  fileName,
+			Headers:   upload.Headers,
+			ExpiresAt: expiresAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+const (
+	// maxPhotoSize limits uploaded photos in bytes.
+	maxPhotoSize = 10 << 20
 
+	photoMaxSide     = 2048
+	thumbnailMaxSide = 320
+)
+
+type ConfirmUploadRequest struct {
+	FileName string `json:"file_name" validate:"required"`
+}
+
+// ownsObjectKey reports whether key is under the user's own prefix.
+func ownsObjectKey(uid int64, key string) bool {
+	return strings.HasPrefix(key, fmt.Sprintf("%d/", uid)) && !strings.Contains(key, "..")
+}
+
+// storePhoto validates an image and stores it re-encoded as JPEG, which
+// drops EXIF data including GPS coordinates, together with a thumbnail.
+// base is the object key without extension.
+func (a *API) storePhoto(uid int64, data []byte, base string) (*db.PhotoUpload, error) {
+	if len(data) > maxPhotoSize {
+		return nil, terrors.BadRequest(fmt.Errorf("photo of %d bytes is too large", len(data)), "photo is too large")
+	}
+
+	img, err := imaging.Decode(data)
 	if err != nil {
-		return terrors.BadRequest(err, "invalid file extension")
+		return nil, terrors.BadRequest(err, "photo is not a supported image")
 	}
 
-	fileName := fmt.Sprintf("%d/%s/%s", uid, time.Now().Format("2006-01-02"), randomString(10)+fileExt)
+	fitted := imaging.Fit(img, photoMaxSide)
 
-	url, err := a.s3Client.GetPresignedURL(fileName, 15*time.Minute)
+	photo, err := imaging.EncodeJPEG(fitted)
+	if err != nil {
+		return nil, terrors.InternalServerError(err, "cannot encode photo")
+	}
 
+	thumbnail, err := imaging.EncodeJPEG(imaging.Fit(img, thumbnailMaxSide))
 	if err != nil {
-		return terrors.InternalServerError(err, "failed to get presigned url")
+		return nil, terrors.InternalServerError(err, "cannot encode thumbnail")
 	}
 
-	res := PresignedURLResponse{
-		URL:      url,
-		FileName: fileName,
+	upload := db.PhotoUpload{
+		ObjectKey:    base + ".jpg",
+		UserID:       uid,
+		ThumbnailKey: base + "_thumb.jpg",
+		Width:        fitted.Bounds().Dx(),
+		Height:       fitted.Bounds().Dy(),
 	}
 
-	return c.JSON(http.StatusOK, res)
+	if _, err := a.blobs.UploadFile(photo, upload.ObjectKey); err != nil {
+		return nil, terrors.InternalServerError(err, "cannot upload photo")
+	}
+
+	if _, err := a.blobs.UploadFile(thumbnail, upload.ThumbnailKey); err != nil {
+		return nil, terrors.InternalServerError(err, "cannot upload thumbnail")
+	}
+
+	if err := a.storage.AddPhotoUpload(upload); err != nil {
+		return nil, terrors.InternalServerError(err, "cannot save photo upload")
+	}
+
+	return &upload, nil
+}
+
+// ConfirmUpload validates a photo uploaded with a presigned URL and replaces
+// it with a normalized copy. Only confirmed photos can be attached to meals.
+func (a *API) ConfirmUpload(c echo.Context) error {
+	uid := getUserID(c)
+
+	var req ConfirmUploadRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	if !ownsObjectKey(uid, req.FileName) {
+		return terrors.BadRequest(fmt.Errorf("key %s is not owned by user %d", req.FileName, uid), "invalid file name")
+	}
+
+	// Photos are stored as JPEG under the key of the original without its
+	// extension, so a repeated confirm finds the upload there even after
+	// the original is gone.
+	base := strings.TrimSuffix(req.FileName, path.Ext(req.FileName))
+
+	if upload, err := a.storage.GetPhotoUpload(uid, base+".jpg"); err == nil {
+		return c.JSON(http.StatusOK, upload)
+	} else if !errors.Is(err, db.ErrNotFound) {
+		return terrors.InternalServerError(err, "cannot get photo upload")
+	}
+
+	ctx := c.Request().Context()
+
+	size, err := a.blobs.HeadFile(ctx, req.FileName)
+	if err != nil {
+		return terrors.BadRequest(err, "photo was not uploaded")
+	}
+
+	if size > maxPhotoSize {
+		a.deleteObject(ctx, req.FileName)
+		return terrors.BadRequest(fmt.Errorf("photo of %d bytes is too large", size), "photo is too large")
+	}
+
+	data, err := a.blobs.GetFile(ctx, req.FileName)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot download photo")
+	}
+
+	upload, err := a.storePhoto(uid, data, base)
+	if err != nil {
+		a.deleteObject(ctx, req.FileName)
+		return err
+	}
+
+	if upload.ObjectKey != req.FileName {
+		a.deleteObject(ctx, req.FileName)
+	}
+
+	return c.JSON(http.StatusOK, upload)
+}
+
+// confirmedPhoto returns the caller's confirmed upload for a photo key.
+func (a *API) confirmedPhoto(uid int64, key string) (*db.PhotoUpload, error) {
+	if !ownsObjectKey(uid, key) {
+		return nil, terrors.BadRequest(fmt.Errorf("key %s is not owned by user %d", key, uid), "invalid photo")
+	}
+
+	upload, err := a.storage.GetPhotoUpload(uid, key)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return nil, terrors.BadRequest(err, "photo upload is not confirmed")
+	} else if err != nil {
+		return nil, terrors.InternalServerError(err, "cannot get photo upload")
+	}
+
+	return upload, nil
+}
+
+func (a *API) deleteObject(ctx context.Context, key string) {
+	if err := a.blobs.DeleteFile(ctx, key); err != nil {
+		log.Printf("Failed to delete %s: %v", key, err)
+	}
 }
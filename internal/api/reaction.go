@@ -0,0 +1,53 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji" validate:"required,oneof=heart fire yum clap wow"`
+}
+
+// ToggleReaction reacts to a meal the caller may see. Sending the emoji the
+// caller already reacted with removes the reaction.
+func (a *API) ToggleReaction(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req ReactionRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	res, err := a.storage.ToggleMealReaction(uid, id, req.Emoji)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot react to meal")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (a *API) DeleteReaction(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	res, err := a.storage.DeleteMealReaction(uid, id)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot remove reaction")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"eatsome/internal/contract"
+	"eatsome/internal/db"
+	"log"
+	"sync"
+	"time"
+)
+
+// readURLTTL is how long signed read URLs are valid in private mode. A
+// cached URL is handed out again until half of that time is left, so
+// clients get a stable URL they can cache for a while.
+const readURLTTL = 30 * time.Minute
+
+type signedURL struct {
+	url       string
+	expiresAt time.Time
+}
+
+// readURLCache keeps signed read URLs by object key.
+type readURLCache struct {
+	mu   sync.Mutex
+	urls map[string]signedURL
+}
+
+// readURL returns a URL the client can load an asset from. With private
+// photos it is a short-lived signed URL, otherwise the asset's public URL
+// as stored. Only call it for assets the viewer is allowed to see.
+func (a *API) readURL(url string) string {
+	if !a.cfg.PrivatePhotos {
+		return url
+	}
+
+	key, ok := a.objectKey(url)
+	if !ok {
+		return url
+	}
+
+	a.readURLs.mu.Lock()
+	defer a.readURLs.mu.Unlock()
+
+	now := time.Now()
+
+	if cached, ok := a.readURLs.urls[key]; ok && cached.expiresAt.Sub(now) > readURLTTL/2 {
+		return cached.url
+	}
+
+	signed, err := a.blobs.GetPresignedDownloadURL(key, readURLTTL)
+	if err != nil {
+		log.Printf("Failed to sign read URL of %s: %v", key, err)
+		return url
+	}
+
+	if a.readURLs.urls == nil {
+		a.readURLs.urls = map[string]signedURL{}
+	}
+
+	for k, cached := range a.readURLs.urls {
+		if cached.expiresAt.Sub(now) <= readURLTTL/2 {
+			delete(a.readURLs.urls, k)
+		}
+	}
+
+	a.readURLs.urls[key] = signedURL{url: signed, expiresAt: now.Add(readURLTTL)}
+
+	return signed
+}
+
+// withReadURLs replaces a meal's photo URLs with ones the owner or a feed
+// viewer can load.
+func (a *API) withReadURLs(meal *db.Meal) *db.Meal {
+	meal.PhotoURL = a.readURL(meal.PhotoURL)
+
+	if meal.ThumbnailURL != nil {
+		thumbnailURL := a.readURL(*meal.ThumbnailURL)
+		meal.ThumbnailURL = &thumbnailURL
+	}
+
+	return meal
+}
+
+func (a *API) avatarReadURL(avatarURL *string) *string {
+	if avatarURL == nil {
+		return nil
+	}
+
+	url := a.readURL(*avatarURL)
+	return &url
+}
+
+func (a *API) userResponse(user *db.User) contract.UserResponse {
+	res := toUserResponse(user)
+	res.AvatarURL = a.avatarReadURL(user.AvatarURL)
+	return res
+}
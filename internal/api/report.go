@@ -0,0 +1,129 @@
+package api
+
+import (
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type ReportRequest struct {
+	Reason  string  `json:"reason" validate:"required,oneof=spam abuse inappropriate other"`
+	Details *string `json:"details" validate:"omitempty,max=1000"`
+}
+
+type AppealRequest struct {
+	Details *string `json:"details" validate:"omitempty,max=1000"`
+}
+
+// ReportMeal reports a meal the caller may see to the moderators.
+func (a *API) ReportMeal(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req ReportRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	report, err := a.storage.ReportMeal(uid, id, req.Reason, req.Details)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil && errors.Is(err, db.ErrAlreadyExists) {
+		return terrors.Conflict(err, "meal already reported")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot report meal")
+	}
+
+	return c.JSON(http.StatusCreated, report)
+}
+
+// ReportComment reports a comment the caller may see to the moderators.
+func (a *API) ReportComment(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req ReportRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	report, err := a.storage.ReportComment(uid, id, req.Reason, req.Details)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "comment not found")
+	} else if err != nil && errors.Is(err, db.ErrAlreadyExists) {
+		return terrors.Conflict(err, "comment already reported")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot report comment")
+	}
+
+	return c.JSON(http.StatusCreated, report)
+}
+
+// AppealMeal asks for the caller's meal that was flagged as spam or removed
+// to be shown again. The meal is analyzed again, which settles the appeal
+// if the meal was only flagged and is no longer found to be spam. Otherwise
+// the appeal waits in the moderation queue.
+func (a *API) AppealMeal(c echo.Context) error {
+	uid := getUserID(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	var req AppealRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	report, err := a.storage.AppealMeal(uid, id, req.Details)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return terrors.NotFound(err, "meal not found")
+	} else if err != nil && errors.Is(err, db.ErrConflict) {
+		return terrors.Conflict(err, "meal is not flagged or removed")
+	} else if err != nil && errors.Is(err, db.ErrAlreadyExists) {
+		return terrors.Conflict(err, "meal already appealed")
+	} else if err != nil {
+		return terrors.InternalServerError(err, "cannot appeal meal")
+	}
+
+	go a.reviewAppeal(uid, id)
+
+	return c.JSON(http.StatusAccepted, report)
+}
+
+// reviewAppeal analyzes an appealed meal again and approves the appeal if
+// the meal is no longer hidden. Ingredients the owner corrected are kept.
+func (a *API) reviewAppeal(uid, mealID int64) {
+	user, err := a.storage.GetUserByID(uid)
+	if err != nil {
+		log.Printf("Failed to get user for appeal of meal %d: %v", mealID, err)
+		return
+	}
+
+	meal, err := a.runAISuggestions(systemActor, userLanguage(user), uid, mealID, false)
+	if err != nil {
+		log.Printf("Failed to analyze appealed meal %d: %v", mealID, err)
+		return
+	}
+
+	if meal.IsSpam || meal.RemovedAt != nil {
+		return
+	}
+
+	if err := a.storage.ApproveAppeals(mealID); err != nil {
+		log.Printf("Failed to approve appeal of meal %d: %v", mealID, err)
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"eatsome/internal/contract"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+func toUserResponse(user *db.User) contract.UserResponse {
+	return contract.UserResponse{
+		ID:                   user.ID,
+		FirstName:            user.FirstName,
+		LastName:             user.LastName,
+		Username:             user.Username,
+		LanguageCode:         user.LanguageCode,
+		ChatID:               user.ChatID,
+		IsPremium:            user.IsPremium,
+		CreatedAt:            user.CreatedAt,
+		UpdatedAt:            user.UpdatedAt,
+		LastSeenAt:           user.LastSeenAt,
+		NotificationsEnabled: user.NotificationsEnabled,
+		AvatarURL:            user.AvatarURL,
+		Title:                user.Title,
+		Timezone:             user.Timezone,
+		DigestEnabled:        user.DigestEnabled,
+		DigestTime:           user.DigestTime,
+		WaterTargetML:        user.WaterTargetML,
+		DefaultVisibility:    user.DefaultVisibility,
+		Role:                 user.Role,
+	}
+}
+
+func (a *API) getUser(uid int64) (*db.User, error) {
+	user, err := a.storage.GetUserByID(uid)
+	if err != nil && errors.Is(err, db.ErrNotFound) {
+		return nil, terrors.NotFound(err, "user not found")
+	} else if err != nil {
+		return nil, terrors.InternalServerError(err, "cannot get user")
+	}
+
+	return user, nil
+}
+
+type UpdateUserSettingsRequest struct {
+	NotificationsEnabled *bool   `json:"notifications_enabled"`
+	Language             *string `json:"language" validate:"omitempty,oneof=en ru"`
+	Timezone             *string `json:"timezone"`
+	DigestEnabled        *bool   `json:"digest_enabled"`
+	DigestTime           *string `json:"digest_time" validate:"omitempty,datetime=15:04"`
+	WaterTargetML        *int    `json:"water_target_ml" validate:"omitempty,min=0,max=10000"`
+	DefaultVisibility    *string `json:"default_visibility" validate:"omitempty,oneof=public followers private"`
+}
+
+func (a *API) UpdateUserSettings(c echo.Context) error {
+	uid := getUserID(c)
+
+	var req UpdateUserSettingsRequest
+	if err := c.Bind(&req); err != nil {
+		return terrors.BadRequest(err, "failed to bind request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	user, err := a.getUser(uid)
+	if err != nil {
+		return err
+	}
+
+	before := userSnapshot(user)
+
+	if req.NotificationsEnabled != nil {
+		user.NotificationsEnabled = *req.NotificationsEnabled
+	}
+
+	if req.Language != nil {
+		user.LanguageCode = req.Language
+	}
+
+	if req.Timezone != nil {
+		if *req.Timezone == "" {
+			return terrors.BadRequest(errors.New("empty timezone"), "invalid timezone")
+		}
+
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			return terrors.BadRequest(err, "invalid timezone")
+		}
+
+		user.Timezone = *req.Timezone
+	}
+
+	if req.DigestEnabled != nil {
+		user.DigestEnabled = *req.DigestEnabled
+	}
+
+	if req.DigestTime != nil {
+		// The scheduler compares digest times as strings, so hours are
+		// stored zero-padded.
+		digestTime, err := time.Parse("15:04", *req.DigestTime)
+		if err != nil {
+			return terrors.BadRequest(err, "invalid digest time")
+		}
+
+		user.DigestTime = digestTime.Format("15:04")
+	}
+
+	if req.WaterTargetML != nil {
+		user.WaterTargetML = *req.WaterTargetML
+	}
+
+	if req.DefaultVisibility != nil {
+		user.DefaultVisibility = *req.DefaultVisibility
+	}
+
+	res, err := a.storage.UpdateUser(uid, *user)
+	if err != nil {
+		return terrors.InternalServerError(err, "cannot update user")
+	}
+
+	a.logChange(requestActor(c), db.ChangeUpdate, db.EntityUser, uid, &uid, before, userSnapshot(res))
+
+	return c.JSON(http.StatusOK, a.userResponse(res))
+}
+
+func userLocation(user *db.User) *time.Location {
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+const dateLayout = "2006-01-02"
+
+// Longest date ranges that can be listed at once. Meal lists include other
+// users' meals and are loaded into memory, so they are kept short.
+const (
+	maxMealRangeDays = 92
+	maxBodyRangeDays = 366
+)
+
+// parseDateRange reads the inclusive "from" and "to" query dates in loc and
+// returns them as a half-open range. Without parameters the range covers the
+// last defaultDays days including today. Ranges longer than maxDays are
+// rejected unless maxDays is zero.
+func parseDateRange(c echo.Context, loc *time.Location, defaultDays, maxDays int) (time.Time, time.Time, error) {
+	end := startOfDay(time.Now().In(loc)).AddDate(0, 0, 1)
+
+	if to := c.QueryParam("to"); to != "" {
+		day, err := time.ParseInLocation(dateLayout, to, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, terrors.BadRequest(err, "invalid to date")
+		}
+		end = day.AddDate(0, 0, 1)
+	}
+
+	start := end.AddDate(0, 0, -defaultDays)
+
+	if from := c.QueryParam("from"); from != "" {
+		day, err := time.ParseInLocation(dateLayout, from, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, terrors.BadRequest(err, "invalid from date")
+		}
+		start = day
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, terrors.BadRequest(errors.New("empty date range"), "from must not be after to")
+	}
+
+	if maxDays > 0 && start.AddDate(0, 0, maxDays).Before(end) {
+		return time.Time{}, time.Time{}, terrors.BadRequest(fmt.Errorf("date range longer than %d days", maxDays), fmt.Sprintf("date range must not exceed %d days", maxDays))
+	}
+
+	return start, end, nil
+}
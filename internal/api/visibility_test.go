@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"eatsome/internal/blob"
+	"eatsome/internal/db"
+	"eatsome/internal/terrors"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testAssetsURL = "https://assets.example"
+
+type testValidator struct {
+	validator *validator.Validate
+}
+
+func (v *testValidator) Validate(i interface{}) error {
+	if err := v.validator.Struct(i); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// visibilityFixture has an owner with a private, a followers-only and a
+// public meal, a stranger and a follower of the owner.
+type visibilityFixture struct {
+	api  *API
+	echo *echo.Echo
+
+	owner, stranger, follower int64
+
+	private, followers, public *db.Meal
+}
+
+func newVisibilityFixture(t *testing.T) *visibilityFixture {
+	t.Helper()
+
+	dbFile := filepath.Join(t.TempDir(), "test.db")
+
+	storage, err := db.NewStorage(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := blob.NewLocal(t.TempDir(), testAssetsURL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &visibilityFixture{
+		api:  New(storage, Config{AssetsURL: testAssetsURL, PrivatePhotos: true}, blobs, nil, nil),
+		echo: echo.New(),
+	}
+	f.echo.Validator = &testValidator{validator: validator.New()}
+
+	lang := "en"
+	for i, uid := range []*int64{&f.owner, &f.stranger, &f.follower} {
+		chatID := int64(i + 1)
+
+		if err := storage.CreateUser(db.User{Username: fmt.Sprintf("user%d", chatID), ChatID: chatID, LanguageCode: &lang}); err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := storage.GetUserByChatID(chatID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*uid = user.ID
+	}
+
+	// There is no endpoint to follow users yet.
+	conn, err := sql.Open("sql", dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec("INSERT INTO followers (follower_id, followee_id) VALUES (?, ?)", f.follower, f.owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// The private meal looks best, so it would top the aesthetic board if
+	// it were allowed to.
+	f.private = f.addMeal(t, db.MealVisibilityPrivate, 10)
+	f.followers = f.addMeal(t, db.MealVisibilityFollowers, 9)
+	f.public = f.addMeal(t, db.MealVisibilityPublic, 5)
+
+	if err := f.api.RefreshLeaderboards(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func (f *visibilityFixture) addMeal(t *testing.T, visibility string, rating int) *db.Meal {
+	t.Helper()
+
+	upload := db.PhotoUpload{
+		ObjectKey:    fmt.Sprintf("%d/2024-01-01/%s.jpg", f.owner, visibility),
+		UserID:       f.owner,
+		ThumbnailKey: fmt.Sprintf("%d/2024-01-01/%s_thumb.jpg", f.owner, visibility),
+		Width:        1,
+		Height:       1,
+	}
+	if err := f.api.storage.AddPhotoUpload(upload); err != nil {
+		t.Fatal(err)
+	}
+
+	meal, err := f.api.storage.AddMeal(f.owner, db.Meal{
+		PhotoURL:   f.api.assetURL(upload.ObjectKey),
+		Visibility: visibility,
+	}, upload.ObjectKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meal, err = f.api.storage.SaveMealAnalysis(f.owner, meal.ID, db.MealAnalysis{
+		DishName:        visibility,
+		AestheticRating: rating,
+		HealthRating:    rating,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return meal
+}
+
+// photoKey is the bucket key of a meal's photo.
+func (f *visibilityFixture) photoKey(meal *db.Meal) string {
+	key, _ := f.api.objectKey(meal.PhotoURL)
+	return key
+}
+
+// call runs a handler as uid and returns the response status and body.
+func (f *visibilityFixture) call(t *testing.T, uid int64, handler echo.HandlerFunc, method, target, body string, params ...string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req = req.WithContext(WithClaims(req.Context(), &JWTClaims{UID: uid, Role: db.RoleUser}))
+
+	rec := httptest.NewRecorder()
+	c := f.echo.NewContext(req, rec)
+
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names = append(names, params[i])
+		values = append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+
+	if err := handler(c); err != nil {
+		var terr *terrors.Error
+		if !errors.As(err, &terr) {
+			t.Fatalf("%s %s: unexpected error %v", method, target, err)
+		}
+		return terr.Code, ""
+	}
+
+	return rec.Code, rec.Body.String()
+}
+
+// mealCalls are the requests that read or act on a single meal.
+func (f *visibilityFixture) mealCalls() map[string]func(t *testing.T, uid int64, id string) (int, string) {
+	return map[string]func(t *testing.T, uid int64, id string) (int, string){
+		"GetMeal": func(t *testing.T, uid int64, id string) (int, string) {
+			return f.call(t, uid, f.api.GetMeal, http.MethodGet, "/meals/"+id, "", "id", id)
+		},
+		"ListComments": func(t *testing.T, uid int64, id string) (int, string) {
+			return f.call(t, uid, f.api.ListComments, http.MethodGet, "/meals/"+id+"/comments", "", "id", id)
+		},
+		"AddComment": func(t *testing.T, uid int64, id string) (int, string) {
+			return f.call(t, uid, f.api.AddComment, http.MethodPost, "/meals/"+id+"/comments", `{"text":"nice"}`, "id", id)
+		},
+		"ToggleReaction": func(t *testing.T, uid int64, id string) (int, string) {
+			return f.call(t, uid, f.api.ToggleReaction, http.MethodPost, "/meals/"+id+"/reactions", `{"emoji":"yum"}`, "id", id)
+		},
+		"DeleteReaction": func(t *testing.T, uid int64, id string) (int, string) {
+			return f.call(t, uid, f.api.DeleteReaction, http.MethodDelete, "/meals/"+id+"/reactions", "", "id", id)
+		},
+		"ReportMeal": func(t *testing.T, uid int64, id string) (int, string) {
+			return f.call(t, uid, f.api.ReportMeal, http.MethodPost, "/meals/"+id+"/report", `{"reason":"spam"}`, "id", id)
+		},
+	}
+}
+
+// listCalls are the requests that list meals or show them on leaderboards.
+func (f *visibilityFixture) listCalls() map[string]func(t *testing.T, uid int64) (int, string) {
+	owner := fmt.Sprint(f.owner)
+
+	return map[string]func(t *testing.T, uid int64) (int, string){
+		"GetMeals": func(t *testing.T, uid int64) (int, string) {
+			return f.call(t, uid, f.api.GetMeals, http.MethodGet, "/meals", "")
+		},
+		"GetUserMeals": func(t *testing.T, uid int64) (int, string) {
+			return f.call(t, uid, f.api.GetUserMeals, http.MethodGet, "/users/"+owner+"/meals", "", "id", owner)
+		},
+		"GetLeaderboard": func(t *testing.T, uid int64) (int, string) {
+			return f.call(t, uid, f.api.GetLeaderboard, http.MethodGet, "/leaderboards/aesthetic", "", "board", db.LeaderboardAesthetic)
+		},
+	}
+}
+
+func TestHiddenMealsReturnNotFound(t *testing.T) {
+	f := newVisibilityFixture(t)
+
+	hidden := []struct {
+		viewer string
+		uid    int64
+		meal   *db.Meal
+	}{
+		{"stranger", f.stranger, f.private},
+		{"stranger", f.stranger, f.followers},
+		{"follower", f.follower, f.private},
+	}
+
+	for name, call := range f.mealCalls() {
+		for _, h := range hidden {
+			code, _ := call(t, h.uid, fmt.Sprint(h.meal.ID))
+			if code != http.StatusNotFound {
+				t.Errorf("%s of %s meal as %s: got status %d, want %d", name, h.meal.Visibility, h.viewer, code, http.StatusNotFound)
+			}
+		}
+
+		// Followers may see and act on followers-only meals.
+		if code, _ := call(t, f.follower, fmt.Sprint(f.followers.ID)); code >= 300 {
+			t.Errorf("%s of followers meal as follower: got status %d", name, code)
+		}
+	}
+}
+
+func TestHiddenMealsAreLeftOutOfLists(t *testing.T) {
+	f := newVisibilityFixture(t)
+
+	for name, call := range f.listCalls() {
+		for _, viewer := range []struct {
+			name    string
+			uid     int64
+			hidden  []*db.Meal
+			visible []*db.Meal
+		}{
+			{"stranger", f.stranger, []*db.Meal{f.private, f.followers}, []*db.Meal{f.public}},
+			{"follower", f.follower, []*db.Meal{f.private}, []*db.Meal{f.public}},
+		} {
+			code, body := call(t, viewer.uid)
+			if code != http.StatusOK {
+				t.Fatalf("%s as %s: got status %d", name, viewer.name, code)
+			}
+
+			for _, meal := range viewer.hidden {
+				if strings.Contains(body, f.photoKey(meal)) || strings.Contains(body, fmt.Sprintf(`"dish_name":%q`, *meal.DishName)) {
+					t.Errorf("%s as %s includes the %s meal: %s", name, viewer.name, meal.Visibility, body)
+				}
+			}
+
+			for _, meal := range viewer.visible {
+				if !strings.Contains(body, f.photoKey(meal)) {
+					t.Errorf("%s as %s is missing the %s meal: %s", name, viewer.name, meal.Visibility, body)
+				}
+			}
+		}
+	}
+
+	// Only the follower sees the followers-only meal in the lists.
+	for _, name := range []string{"GetMeals", "GetUserMeals"} {
+		if _, body := f.listCalls()[name](t, f.follower); !strings.Contains(body, f.photoKey(f.followers)) {
+			t.Errorf("%s as follower is missing the followers meal: %s", name, body)
+		}
+	}
+}
+
+func TestHiddenMealPhotosAreNotSigned(t *testing.T) {
+	f := newVisibilityFixture(t)
+
+	for _, uid := range []int64{f.stranger, f.follower} {
+		for _, call := range f.mealCalls() {
+			call(t, uid, fmt.Sprint(f.private.ID))
+		}
+
+		for _, call := range f.listCalls() {
+			call(t, uid)
+		}
+	}
+
+	f.api.readURLs.mu.Lock()
+	defer f.api.readURLs.mu.Unlock()
+
+	if _, ok := f.api.readURLs.urls[f.photoKey(f.private)]; ok {
+		t.Errorf("signed a read URL for the private photo")
+	}
+
+	if _, ok := f.api.readURLs.urls[f.photoKey(f.followers)]; !ok {
+		t.Errorf("did not sign a read URL for the followers photo shown to the follower")
+	}
+}
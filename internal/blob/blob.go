@@ -0,0 +1,66 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by GetFile for objects that do not exist.
+var ErrNotFound = errors.New("object not found")
+
+// BlobStore keeps uploaded photos, avatars and exports. Keys are slash
+// separated paths such as "{uid}/2024-01-01/abc.jpg".
+type BlobStore interface {
+	// GetPresignedURL returns a PUT request that uploads exactly size bytes
+	// of the content type matching the key's extension.
+	GetPresignedURL(objectKey string, size int64, duration time.Duration) (*PresignedUpload, error)
+	// GetPresignedDownloadURL returns a URL that allows downloading an object
+	// for the given duration.
+	GetPresignedDownloadURL(objectKey string, duration time.Duration) (string, error)
+	UploadFile(file []byte, fileName string) (string, error)
+	// UploadStream stores an object read from r without holding all of it
+	// in memory. A failed upload leaves no object behind.
+	UploadStream(ctx context.Context, r io.Reader, fileName string) error
+	// GetFile returns the content of an object, or ErrNotFound.
+	GetFile(ctx context.Context, fileName string) ([]byte, error)
+	// HeadFile returns the size of an object in bytes.
+	HeadFile(ctx context.Context, fileName string) (int64, error)
+	// DeleteFile removes an object. Deleting a missing object is not an error.
+	DeleteFile(ctx context.Context, fileName string) error
+	// DeletePrefix removes every object whose key starts with prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// ListFiles returns the keys of objects that start with prefix.
+	ListFiles(ctx context.Context, prefix string) ([]string, error)
+}
+
+// PresignedUpload is a presigned PUT request. Headers must be sent as they
+// are, since the content type and length are part of the signature.
+type PresignedUpload struct {
+	URL     string
+	Headers map[string]string
+}
+
+// ContentType returns the content type stored for a key by its extension.
+func ContentType(fileName string) string {
+	switch {
+	case strings.HasSuffix(fileName, ".jpg"):
+		return "image/jpeg"
+	case strings.HasSuffix(fileName, ".jpeg"):
+		return "image/jpeg"
+	case strings.HasSuffix(fileName, ".png"):
+		return "image/png"
+	case strings.HasSuffix(fileName, ".gif"):
+		return "image/gif"
+	case strings.HasSuffix(fileName, ".webp"):
+		return "image/webp"
+	case strings.HasSuffix(fileName, ".heic"):
+		return "image/heic"
+	case strings.HasSuffix(fileName, ".zip"):
+		return "application/zip"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,309 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ BlobStore = (*Local)(nil)
+
+// Local keeps objects in a directory on disk for development. It serves
+// them over HTTP from the API process, with presigned URLs signed by an
+// HMAC of the request instead of by a bucket.
+type Local struct {
+	dir     string
+	baseURL string
+	secret  []byte
+
+	// Private makes objects readable only through presigned URLs, like a
+	// bucket without a public domain.
+	Private bool
+}
+
+// NewLocal creates a store in dir whose objects are served at baseURL,
+// where the API mounts the store's handler.
+func NewLocal(dir, baseURL, secret string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	return &Local{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  []byte(secret),
+	}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) GetPresignedURL(objectKey string, size int64, duration time.Duration) (*PresignedUpload, error) {
+	if _, err := l.path(objectKey); err != nil {
+		return nil, err
+	}
+
+	contentType := ContentType(objectKey)
+	expires := strconv.FormatInt(time.Now().Add(duration).Unix(), 10)
+	sizeStr := strconv.FormatInt(size, 10)
+
+	query := url.Values{
+		"expires":   {expires},
+		"size":      {sizeStr},
+		"signature": {l.sign(http.MethodPut, objectKey, expires, contentType, sizeStr)},
+	}
+
+	return &PresignedUpload{
+		URL:     fmt.Sprintf("%s/%s?%s", l.baseURL, objectKey, query.Encode()),
+		Headers: map[string]string{"Content-Type": contentType},
+	}, nil
+}
+
+func (l *Local) GetPresignedDownloadURL(objectKey string, duration time.Duration) (string, error) {
+	if _, err := l.path(objectKey); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(duration).Unix(), 10)
+
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {l.sign(http.MethodGet, objectKey, expires)},
+	}
+
+	return fmt.Sprintf("%s/%s?%s", l.baseURL, objectKey, query.Encode()), nil
+}
+
+func (l *Local) UploadFile(file []byte, fileName string) (string, error) {
+	if err := l.write(fileName, bytes.NewReader(file)); err != nil {
+		return "", err
+	}
+
+	return l.GetPresignedDownloadURL(fileName, time.Hour)
+}
+
+func (l *Local) UploadStream(_ context.Context, r io.Reader, fileName string) error {
+	return l.write(fileName, r)
+}
+
+// write stores an object through a temporary file so that readers never
+// see it half written.
+func (l *Local) write(key string, r io.Reader) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) GetFile(_ context.Context, fileName string) ([]byte, error) {
+	p, err := l.path(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", fileName, ErrNotFound)
+	}
+
+	return data, err
+}
+
+func (l *Local) HeadFile(_ context.Context, fileName string) (int64, error) {
+	p, err := l.path(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (l *Local) DeleteFile(_ context.Context, fileName string) error {
+	p, err := l.path(fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) DeletePrefix(ctx context.Context, prefix string) error {
+	keys, err := l.ListFiles(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := l.DeleteFile(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Local) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	err := filepath.WalkDir(l.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+
+	return keys, err
+}
+
+// ServeHTTP serves objects by key, like the bucket's public domain, and
+// accepts uploads to presigned URLs. Requests with a download signature are
+// rejected once it expires, and private stores reject requests without one.
+// The handler expects the mount path to be stripped from the request path.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	p, err := l.path(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if (l.Private || query.Has("signature")) && !l.verify(query, http.MethodGet, key, query.Get("expires")) {
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType(key))
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	case http.MethodPut:
+		contentType := r.Header.Get("Content-Type")
+		size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+
+		if err != nil || !l.verify(query, http.MethodPut, key, query.Get("expires"), contentType, query.Get("size")) {
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+
+		if r.ContentLength != size {
+			http.Error(w, "content length does not match the signed size", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, size+1))
+		if err != nil || int64(len(body)) != size {
+			http.Error(w, "content length does not match the signed size", http.StatusBadRequest)
+			return
+		}
+
+		if err := l.write(key, bytes.NewReader(body)); err != nil {
+			http.Error(w, "failed to store object", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify checks a signature made by sign over method, key and the given
+// values, the first of which is the expiry time.
+func (l *Local) verify(query url.Values, method, key string, values ...string) bool {
+	expires, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	want := l.sign(append([]string{method, key}, values...)...)
+
+	return hmac.Equal([]byte(want), []byte(query.Get("signature")))
+}
@@ -25,4 +25,10 @@ type UserResponse struct {
 	NotificationsEnabled bool      `json:"notifications_enabled"`
 	AvatarURL            *string   `json:"avatar_url"`
 	Title                *string   `json:"title"`
+	Timezone             string    `json:"timezone"`
+	DigestEnabled        bool      `json:"digest_enabled"`
+	DigestTime           string    `json:"digest_time"`
+	WaterTargetML        int       `json:"water_target_ml"`
+	DefaultVisibility    string    `json:"default_visibility"`
+	Role                 string    `json:"role"`
 }
@@ -0,0 +1,101 @@
+package db
+
+import "time"
+
+// ObjectDeletion is a bucket object, or every object under a prefix, that is
+// queued for removal. The queue outlives the rows that referenced the
+// objects so that removal can be retried while the bucket is unreachable.
+type ObjectDeletion struct {
+	ID       int64  `db:"id"`
+	Key      string `db:"object_key"`
+	IsPrefix bool   `db:"is_prefix"`
+}
+
+// SetAccountDeletionCode stores the code that confirms deleting the user's
+// account, replacing any earlier one.
+func (s *storage) SetAccountDeletionCode(uid int64, code string, expiresAt time.Time) error {
+	q := `
+		INSERT INTO account_deletions (user_id, code, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET code = excluded.code, expires_at = excluded.expires_at
+	`
+
+	_, err := s.db.Exec(q, uid, code, formatTimestamp(expiresAt))
+
+	return err
+}
+
+// DeleteUserByID deletes the user and, through ON DELETE CASCADE, all rows
+// that belong to them, queueing objects for removal from the bucket in the
+// same transaction. code must be an unexpired code set with
+// SetAccountDeletionCode, otherwise ErrNotFound is returned.
+func (s *storage) DeleteUserByID(uid int64, code string, objects []ObjectDeletion) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	q := `
+		DELETE FROM account_deletions
+		WHERE user_id = ? AND code = ? AND expires_at > ?
+	`
+
+	res, err := tx.Exec(q, uid, code, formatTimestamp(time.Now()))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+		return ErrNotFound
+	}
+
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, o := range objects {
+		if _, err := tx.Exec("INSERT INTO object_deletions (object_key, is_prefix) VALUES (?, ?)", o.Key, o.IsPrefix); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListObjectDeletions returns queued object deletions, oldest first.
+func (s *storage) ListObjectDeletions() ([]ObjectDeletion, error) {
+	var objects []ObjectDeletion
+
+	rows, err := s.db.Query("SELECT id, object_key, is_prefix FROM object_deletions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var o ObjectDeletion
+		if err := rows.Scan(&o.ID, &o.Key, &o.IsPrefix); err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// DeleteObjectDeletion removes a queued deletion once the objects are gone.
+func (s *storage) DeleteObjectDeletion(id int64) error {
+	_, err := s.db.Exec("DELETE FROM object_deletions WHERE id = ?", id)
+
+	return err
+}
@@ -0,0 +1,85 @@
+package db
+
+import "time"
+
+const (
+	AchievementFirstMeal     = "first_meal"
+	AchievementStreak7       = "streak_7"
+	AchievementHealthyMeals  = "healthy_30"
+	AchievementProteinStreak = "protein_5"
+)
+
+type Achievement struct {
+	ID         string    `db:"achievement" json:"id"`
+	UnlockedAt time.Time `db:"unlocked_at" json:"unlocked_at"`
+}
+
+// MealCounts counts the user's meals that are not spam.
+type MealCounts struct {
+	Meals int
+	// Healthy counts meals rated at least the given health rating.
+	Healthy int
+}
+
+func (s *storage) GetMealCounts(uid int64, minHealthRating int) (*MealCounts, error) {
+	var c MealCounts
+
+	q := `
+		SELECT COUNT(*), COALESCE(SUM(health_rating >= ?), 0)
+		FROM meals
+		WHERE user_id = ? AND is_spam = FALSE AND deleted_at IS NULL
+	`
+
+	if err := s.db.QueryRow(q, minHealthRating, uid).Scan(&c.Meals, &c.Healthy); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// UnlockAchievement records an achievement of the user. It returns false if
+// it was already unlocked, keeping the original time.
+func (s *storage) UnlockAchievement(uid int64, achievement string) (bool, error) {
+	res, err := s.db.Exec(`
+		INSERT INTO user_achievements (user_id, achievement) VALUES (?, ?)
+		ON CONFLICT (user_id, achievement) DO NOTHING
+	`, uid, achievement)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// ListAchievements returns the user's achievements in the order they were
+// unlocked.
+func (s *storage) ListAchievements(uid int64) ([]Achievement, error) {
+	achievements := make([]Achievement, 0)
+
+	rows, err := s.db.Query("SELECT achievement, unlocked_at FROM user_achievements WHERE user_id = ? ORDER BY unlocked_at, achievement", uid)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Achievement
+		if err := rows.Scan(&a.ID, &a.UnlockedAt); err != nil {
+			return nil, err
+		}
+
+		achievements = append(achievements, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return achievements, nil
+}
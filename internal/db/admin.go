@@ -0,0 +1,191 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditLogEntry records an action taken with elevated rights. ActorID is
+// the operator. For requests made while impersonating a user,
+// ImpersonatedID is that user.
+//
+// The audit log answers what staff did, for admins reviewing them, and
+// also holds actions that change no data, such as impersonating. What an
+// action changed is in the change log, which owners see as their history.
+// Admin and moderator actions that change data are therefore written to both.
+type AuditLogEntry struct {
+	ID             int64     `db:"id" json:"id"`
+	ActorID        *int64    `db:"actor_id" json:"actor_id"`
+	ImpersonatedID *int64    `db:"impersonated_id" json:"impersonated_id"`
+	Action         string    `db:"action" json:"action"`
+	TargetUserID   *int64    `db:"target_user_id" json:"target_user_id"`
+	TargetID       *int64    `db:"target_id" json:"target_id"`
+	Details        *string   `db:"details" json:"details"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+type RecognitionFailure struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    int64     `db:"user_id" json:"user_id"`
+	MealID    int64     `db:"meal_id" json:"meal_id"`
+	Error     string    `db:"error" json:"error"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// SearchUsers finds users whose username or name contains query, or whose
+// ID or chat ID is query, ordered by ID.
+func (s *storage) SearchUsers(query string, limit int) ([]User, error) {
+	var users []User
+
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+
+	var id int64 = -1
+	if n, err := strconv.ParseInt(query, 10, 64); err == nil {
+		id = n
+	}
+
+	q := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE username LIKE ? ESCAPE '\' OR first_name LIKE ? ESCAPE '\' OR last_name LIKE ? ESCAPE '\'
+		   OR id = ? OR chat_id = ?
+		ORDER BY id
+		LIMIT ?
+	`
+
+	rows, err := s.db.Query(q, pattern, pattern, pattern, id, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetUserBanned bans or unbans a user. Banning a banned user keeps the
+// original time.
+func (s *storage) SetUserBanned(uid int64, banned bool) (*User, error) {
+	q := "UPDATE users SET banned_at = NULL WHERE id = ?"
+	if banned {
+		q = "UPDATE users SET banned_at = COALESCE(banned_at, CURRENT_TIMESTAMP) WHERE id = ?"
+	}
+
+	res, err := s.db.Exec(q, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.GetUserByID(uid)
+}
+
+func (s *storage) SetUserRole(uid int64, role string) (*User, error) {
+	res, err := s.db.Exec("UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", role, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.GetUserByID(uid)
+}
+
+func (s *storage) AddAuditLogEntry(e AuditLogEntry) error {
+	q := `
+		INSERT INTO audit_log (actor_id, impersonated_id, action, target_user_id, target_id, details)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(q, e.ActorID, e.ImpersonatedID, e.Action, e.TargetUserID, e.TargetID, e.Details)
+
+	return err
+}
+
+// ListAuditLog returns the latest entries, newest first. A non-zero
+// targetUserID limits them to actions on or as that user.
+func (s *storage) ListAuditLog(targetUserID int64, limit int) ([]AuditLogEntry, error) {
+	var entries []AuditLogEntry
+
+	q := `
+		SELECT id, actor_id, impersonated_id, action, target_user_id, target_id, details, created_at
+		FROM audit_log
+		WHERE ? = 0 OR target_user_id = ? OR impersonated_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+
+	rows, err := s.db.Query(q, targetUserID, targetUserID, targetUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditLogEntry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ImpersonatedID, &e.Action, &e.TargetUserID, &e.TargetID, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *storage) AddRecognitionFailure(uid, mealID int64, message string) error {
+	_, err := s.db.Exec("INSERT INTO recognition_failures (user_id, meal_id, error) VALUES (?, ?, ?)", uid, mealID, message)
+
+	return err
+}
+
+// ListRecognitionFailures returns the latest failures, newest first.
+func (s *storage) ListRecognitionFailures(limit int) ([]RecognitionFailure, error) {
+	var failures []RecognitionFailure
+
+	rows, err := s.db.Query("SELECT id, user_id, meal_id, error, created_at FROM recognition_failures ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var f RecognitionFailure
+		if err := rows.Scan(&f.ID, &f.UserID, &f.MealID, &f.Error, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		failures = append(failures, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
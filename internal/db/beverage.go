@@ -0,0 +1,104 @@
+package db
+
+import "time"
+
+type Beverage struct {
+	ID           int64         `db:"id" json:"id"`
+	UserID       int64         `db:"user_id" json:"user_id"`
+	BeverageType string        `db:"beverage_type" json:"beverage_type"`
+	VolumeML     int           `db:"volume_ml" json:"volume_ml"`
+	FoodInsights *FoodInsights `db:"food_insights" json:"food_insights"`
+	ConsumedAt   time.Time     `db:"consumed_at" json:"consumed_at"`
+	CreatedAt    time.Time     `db:"created_at" json:"created_at"`
+}
+
+const beverageColumns = "id, user_id, beverage_type, volume_ml, food_insights, consumed_at, created_at"
+
+func scanBeverage(row interface{ Scan(...interface{}) error }, b *Beverage) error {
+	return row.Scan(
+		&b.ID,
+		&b.UserID,
+		&b.BeverageType,
+		&b.VolumeML,
+		&b.FoodInsights,
+		&b.ConsumedAt,
+		&b.CreatedAt,
+	)
+}
+
+func (s *storage) GetBeverage(uid, id int64) (*Beverage, error) {
+	var b Beverage
+
+	row := s.db.QueryRow("SELECT "+beverageColumns+" FROM beverages WHERE id = ? AND user_id = ?", id, uid)
+
+	if err := scanBeverage(row, &b); err != nil && IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (s *storage) AddBeverage(uid int64, b Beverage) (*Beverage, error) {
+	q := `
+		INSERT INTO beverages (user_id, beverage_type, volume_ml, food_insights, consumed_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	res, err := s.db.Exec(q, uid, b.BeverageType, b.VolumeML, b.FoodInsights, formatTimestamp(b.ConsumedAt))
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetBeverage(uid, id)
+}
+
+func (s *storage) DeleteBeverage(uid, id int64) error {
+	res, err := s.db.Exec("DELETE FROM beverages WHERE id = ? AND user_id = ?", id, uid)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListBeverages returns beverages consumed in [startDate, endDate), oldest first.
+func (s *storage) ListBeverages(uid int64, startDate, endDate time.Time) ([]Beverage, error) {
+	var beverages []Beverage
+
+	q := "SELECT " + beverageColumns + ` FROM beverages
+		WHERE user_id = ? AND consumed_at >= ? AND consumed_at < ?
+		ORDER BY consumed_at`
+
+	rows, err := s.db.Query(q, uid, formatTimestamp(startDate), formatTimestamp(endDate))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Beverage
+		if err := scanBeverage(rows, &b); err != nil {
+			return nil, err
+		}
+
+		beverages = append(beverages, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return beverages, nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import "time"
+
+// BodyMetric is a body measurement. Values are stored in the unit they were
+// entered in; any of them may be missing.
+type BodyMetric struct {
+	ID             int64     `db:"id" json:"id"`
+	UserID         int64     `db:"user_id" json:"user_id"`
+	Weight         *float64  `db:"weight" json:"weight"`
+	WeightUnit     string    `db:"weight_unit" json:"weight_unit"`
+	BodyFatPercent *float64  `db:"body_fat_percent" json:"body_fat_percent"`
+	Waist          *float64  `db:"waist" json:"waist"`
+	WaistUnit      string    `db:"waist_unit" json:"waist_unit"`
+	MeasuredAt     time.Time `db:"measured_at" json:"measured_at"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+}
+
+const bodyMetricColumns = "id, user_id, weight, weight_unit, body_fat_percent, waist, waist_unit, measured_at, created_at, updated_at"
+
+func scanBodyMetric(row interface{ Scan(...interface{}) error }, m *BodyMetric) error {
+	return row.Scan(
+		&m.ID,
+		&m.UserID,
+		&m.Weight,
+		&m.WeightUnit,
+		&m.BodyFatPercent,
+		&m.Waist,
+		&m.WaistUnit,
+		&m.MeasuredAt,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	)
+}
+
+func (s *storage) GetBodyMetric(uid, id int64) (*BodyMetric, error) {
+	var m BodyMetric
+
+	row := s.db.QueryRow("SELECT "+bodyMetricColumns+" FROM body_metrics WHERE id = ? AND user_id = ?", id, uid)
+
+	if err := scanBodyMetric(row, &m); err != nil && IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (s *storage) AddBodyMetric(uid int64, m BodyMetric) (*BodyMetric, error) {
+	q := `
+		INSERT INTO body_metrics (user_id, weight, weight_unit, body_fat_percent, waist, waist_unit, measured_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	res, err := s.db.Exec(q, uid, m.Weight, m.WeightUnit, m.BodyFatPercent, m.Waist, m.WaistUnit, formatTimestamp(m.MeasuredAt))
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetBodyMetric(uid, id)
+}
+
+func (s *storage) UpdateBodyMetric(uid, id int64, m BodyMetric) (*BodyMetric, error) {
+	q := `
+		UPDATE body_metrics
+		SET weight = ?, weight_unit = ?, body_fat_percent = ?, waist = ?, waist_unit = ?, measured_at = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?
+	`
+
+	res, err := s.db.Exec(q, m.Weight, m.WeightUnit, m.BodyFatPercent, m.Waist, m.WaistUnit, formatTimestamp(m.MeasuredAt), id, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.GetBodyMetric(uid, id)
+}
+
+func (s *storage) DeleteBodyMetric(uid, id int64) error {
+	res, err := s.db.Exec("DELETE FROM body_metrics WHERE id = ? AND user_id = ?", id, uid)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListBodyMetrics returns measurements taken in [startDate, endDate), oldest first.
+func (s *storage) ListBodyMetrics(uid int64, startDate, endDate time.Time) ([]BodyMetric, error) {
+	var metrics []BodyMetric
+
+	q := "SELECT " + bodyMetricColumns + ` FROM body_metrics
+		WHERE user_id = ? AND measured_at >= ? AND measured_at < ?
+		ORDER BY measured_at`
+
+	rows, err := s.db.Query(q, uid, formatTimestamp(startDate), formatTimestamp(endDate))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var m BodyMetric
+		if err := scanBodyMetric(rows, &m); err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,40 @@
+package db
+
+import "time"
+
+// ClaimBotUpdate records that a Telegram update is being handled. It returns
+// false when the update was seen before, so redeliveries are ignored.
+func (s *storage) ClaimBotUpdate(updateID int64) (bool, error) {
+	q := `
+		INSERT INTO bot_updates (update_id)
+		VALUES (?)
+		ON CONFLICT (update_id) DO NOTHING
+	`
+
+	res, err := s.db.Exec(q, updateID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// ReleaseBotUpdate removes a claim so that a redelivery is handled.
+func (s *storage) ReleaseBotUpdate(updateID int64) error {
+	_, err := s.db.Exec(`DELETE FROM bot_updates WHERE update_id = ?`, updateID)
+
+	return err
+}
+
+// PurgeBotUpdates forgets updates received before the given time, which
+// Telegram no longer redelivers.
+func (s *storage) PurgeBotUpdates(receivedBefore time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM bot_updates WHERE received_at < ?`, formatTimestamp(receivedBefore))
+
+	return err
+}
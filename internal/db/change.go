@@ -0,0 +1,99 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Who made a change. Admin covers moderators and admins acting on someone
+// else's data, including while impersonating them.
+const (
+	ActorUser   = "user"
+	ActorSystem = "system"
+	ActorAdmin  = "admin"
+)
+
+const (
+	EntityMeal    = "meal"
+	EntityUser    = "user"
+	EntityTag     = "tag"
+	EntityComment = "comment"
+)
+
+const (
+	ChangeCreate  = "create"
+	ChangeUpdate  = "update"
+	ChangeAnalyze = "analyze"
+	ChangeDelete  = "delete"
+	ChangeRestore = "restore"
+	ChangePurge   = "purge"
+)
+
+// Actor is who made a change. ID is nil for the system.
+type Actor struct {
+	Type string `json:"type"`
+	ID   *int64 `json:"id"`
+}
+
+// Change is an entry of the change log, which is append-only. OwnerID is
+// the user whose data changed; their entries go when their account does.
+// Diff maps each changed field to its value before and after the change.
+type Change struct {
+	ID        int64           `db:"id" json:"id"`
+	Actor     Actor           `json:"actor"`
+	Action    string          `db:"action" json:"action"`
+	Entity    string          `db:"entity" json:"entity"`
+	EntityID  int64           `db:"entity_id" json:"entity_id"`
+	OwnerID   *int64          `db:"owner_id" json:"owner_id"`
+	Diff      json.RawMessage `db:"diff" json:"diff"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
+
+func (s *storage) AddChange(c Change) error {
+	q := `
+		INSERT INTO change_log (actor_type, actor_id, action, entity, entity_id, owner_id, diff)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(q, c.Actor.Type, c.Actor.ID, c.Action, c.Entity, c.EntityID, c.OwnerID, string(c.Diff))
+
+	return err
+}
+
+// ListChanges returns the changes of an entity owned by ownerID, oldest
+// first.
+func (s *storage) ListChanges(entity string, entityID, ownerID int64) ([]Change, error) {
+	changes := make([]Change, 0)
+
+	q := `
+		SELECT id, actor_type, actor_id, action, entity, entity_id, owner_id, diff, created_at
+		FROM change_log
+		WHERE entity = ? AND entity_id = ? AND owner_id = ?
+		ORDER BY id
+	`
+
+	rows, err := s.db.Query(q, entity, entityID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Change
+		var diff string
+
+		if err := rows.Scan(&c.ID, &c.Actor.Type, &c.Actor.ID, &c.Action, &c.Entity, &c.EntityID, &c.OwnerID, &diff, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		c.Diff = json.RawMessage(diff)
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import "time"
+
+type Comment struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    int64     `db:"user_id" json:"user_id"`
+	MealID    int64     `db:"meal_id" json:"meal_id"`
+	Text      string    `db:"text" json:"text"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	// RemovedAt is set when a moderator removes the comment. Removed
+	// comments are only shown to their author.
+	RemovedAt *time.Time `db:"removed_at" json:"removed_at"`
+}
+
+// visibleComment is a condition on comments aliased c that hides removed
+// comments and comments of banned users from everyone but their author,
+// whose ID is the only argument.
+const visibleComment = `(c.user_id = ? OR (c.removed_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM users b WHERE b.id = c.user_id AND b.banned_at IS NOT NULL)))`
+
+// ListUserComments returns the comments the user wrote.
+func (s *storage) ListUserComments(uid int64) ([]Comment, error) {
+	return s.listComments("SELECT id, user_id, meal_id, text, created_at, removed_at FROM comments WHERE user_id = ? ORDER BY id", uid)
+}
+
+// ListMealComments returns the comments on a meal viewer may see. It returns
+// ErrNotFound when the meal is missing or hidden from viewer.
+func (s *storage) ListMealComments(viewerID, mealID int64) ([]Comment, error) {
+	if _, err := s.GetVisibleMeal(viewerID, mealID); err != nil {
+		return nil, err
+	}
+
+	q := `
+		SELECT c.id, c.user_id, c.meal_id, c.text, c.created_at, c.removed_at
+		FROM comments c
+				 JOIN meals m ON m.id = c.meal_id
+		WHERE c.meal_id = ? AND m.deleted_at IS NULL AND ` + visibleMeal + ` AND ` + visibleComment + `
+		ORDER BY c.id
+	`
+
+	return s.listComments(q, mealID, viewerID, viewerID, viewerID)
+}
+
+// AddComment adds a comment by viewer on a meal they may see. It returns
+// ErrNotFound when the meal is missing or hidden from viewer.
+func (s *storage) AddComment(viewerID, mealID int64, text string) (*Comment, error) {
+	q := `
+		INSERT INTO comments (user_id, meal_id, text)
+		SELECT ?, m.id, ?
+		FROM meals m
+		WHERE m.id = ? AND m.deleted_at IS NULL AND ` + visibleMeal + `
+		RETURNING id, user_id, meal_id, text, created_at, removed_at
+	`
+
+	var c Comment
+	err := s.db.QueryRow(q, viewerID, text, mealID, viewerID, viewerID).Scan(&c.ID, &c.UserID, &c.MealID, &c.Text, &c.CreatedAt, &c.RemovedAt)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (s *storage) listComments(query string, args ...interface{}) ([]Comment, error) {
+	var comments []Comment
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.UserID, &c.MealID, &c.Text, &c.CreatedAt, &c.RemovedAt); err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
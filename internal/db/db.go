@@ -66,6 +66,13 @@ func NewStorage(dbFile string) (*storage, error) {
 		    notifications_enabled BOOLEAN NOT NULL DEFAULT TRUE,
 		    avatar_url TEXT,
 		    title TEXT,
+		    timezone TEXT NOT NULL DEFAULT 'UTC',
+		    digest_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		    digest_time TEXT NOT NULL DEFAULT '21:00',
+		    water_target_ml INTEGER NOT NULL DEFAULT 2000,
+		    default_visibility TEXT NOT NULL DEFAULT 'public' CHECK (default_visibility IN ('public', 'followers', 'private')),
+		    role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+		    banned_at TIMESTAMP,
 		    UNIQUE (chat_id)
 		);
 
@@ -84,6 +91,17 @@ func NewStorage(dbFile string) (*storage, error) {
 		    food_insights TEXT,
 		    aesthetic_rating INTEGER,
 		    health_rating INTEGER,
+		    eaten_at TIMESTAMP,
+		    meal_type TEXT CHECK (meal_type IN ('breakfast', 'lunch', 'dinner', 'snack')),
+		    ai_ingredients TEXT,
+		    ai_food_insights TEXT,
+		    ingredients_edited_at TIMESTAMP,
+		    version INTEGER NOT NULL DEFAULT 1,
+		    deleted_at TIMESTAMP,
+		    import_key TEXT,
+		    thumbnail_url TEXT,
+		    visibility TEXT NOT NULL DEFAULT 'public' CHECK (visibility IN ('public', 'followers', 'private')),
+		    removed_at TIMESTAMP,
 		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
 		);
 
@@ -93,6 +111,57 @@ func NewStorage(dbFile string) (*storage, error) {
 		    meal_id INTEGER NOT NULL,
 		    text TEXT NOT NULL,
 		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    removed_at TIMESTAMP,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		    FOREIGN KEY (meal_id) REFERENCES meals (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS reports (
+		    id INTEGER PRIMARY KEY,
+		    reporter_id INTEGER NOT NULL,
+		    meal_id INTEGER,
+		    comment_id INTEGER,
+		    reason TEXT NOT NULL CHECK (reason IN ('spam', 'abuse', 'inappropriate', 'other', 'appeal')),
+		    details TEXT,
+		    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'approved', 'removed', 'banned')),
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    resolved_at TIMESTAMP,
+		    resolved_by INTEGER,
+		    CHECK ((meal_id IS NULL) != (comment_id IS NULL)),
+		    FOREIGN KEY (reporter_id) REFERENCES users (id) ON DELETE CASCADE,
+		    FOREIGN KEY (meal_id) REFERENCES meals (id) ON DELETE CASCADE,
+		    FOREIGN KEY (comment_id) REFERENCES comments (id) ON DELETE CASCADE,
+		    FOREIGN KEY (resolved_by) REFERENCES users (id) ON DELETE SET NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS meal_reactions (
+		    meal_id INTEGER NOT NULL,
+		    user_id INTEGER NOT NULL,
+		    emoji TEXT NOT NULL CHECK (emoji IN ('heart', 'fire', 'yum', 'clap', 'wow')),
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    PRIMARY KEY (meal_id, user_id),
+		    FOREIGN KEY (meal_id) REFERENCES meals (id) ON DELETE CASCADE,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS user_achievements (
+		    user_id INTEGER NOT NULL,
+		    achievement TEXT NOT NULL,
+		    unlocked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    PRIMARY KEY (user_id, achievement),
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS leaderboard_entries (
+		    board TEXT NOT NULL CHECK (board IN ('aesthetic', 'health', 'streak')),
+		    period TEXT NOT NULL CHECK (period IN ('week', 'month')),
+		    period_start TEXT NOT NULL,
+		    user_id INTEGER NOT NULL,
+		    meal_id INTEGER,
+		    score REAL NOT NULL,
+		    meals INTEGER NOT NULL,
+		    computed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    PRIMARY KEY (board, period, period_start, user_id),
 		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
 		    FOREIGN KEY (meal_id) REFERENCES meals (id) ON DELETE CASCADE
 		);
@@ -121,8 +190,167 @@ func NewStorage(dbFile string) (*storage, error) {
 		    FOREIGN KEY (tag_id) REFERENCES tags (id) ON DELETE CASCADE
 		);
 
+		CREATE TABLE IF NOT EXISTS scheduled_notifications (
+		    id INTEGER PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    kind TEXT NOT NULL,
+		    local_date TEXT NOT NULL,
+		    sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    UNIQUE (user_id, kind, local_date),
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS bot_updates (
+		    update_id INTEGER PRIMARY KEY,
+		    received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS user_goals (
+		    id INTEGER PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    weekday INTEGER,
+		    calories INTEGER NOT NULL,
+		    proteins INTEGER NOT NULL,
+		    fats INTEGER NOT NULL,
+		    carbohydrates INTEGER NOT NULL,
+		    source TEXT NOT NULL DEFAULT 'manual',
+		    effective_from TEXT NOT NULL,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    UNIQUE (user_id, effective_from, weekday),
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS body_metrics (
+		    id INTEGER PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    weight REAL,
+		    weight_unit TEXT NOT NULL DEFAULT 'kg',
+		    body_fat_percent REAL,
+		    waist REAL,
+		    waist_unit TEXT NOT NULL DEFAULT 'cm',
+		    measured_at TIMESTAMP NOT NULL,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS beverages (
+		    id INTEGER PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    beverage_type TEXT NOT NULL,
+		    volume_ml INTEGER NOT NULL,
+		    food_insights TEXT,
+		    consumed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS account_deletions (
+		    user_id INTEGER PRIMARY KEY,
+		    code TEXT NOT NULL,
+		    expires_at TIMESTAMP NOT NULL,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS object_deletions (
+		    id INTEGER PRIMARY KEY,
+		    object_key TEXT NOT NULL,
+		    is_prefix BOOLEAN NOT NULL DEFAULT FALSE,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS data_exports (
+		    id INTEGER PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+		    object_key TEXT,
+		    error TEXT,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    started_at TIMESTAMP,
+		    completed_at TIMESTAMP,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS photo_uploads (
+		    object_key TEXT PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    thumbnail_key TEXT NOT NULL,
+		    width INTEGER NOT NULL,
+		    height INTEGER NOT NULL,
+		    meal_id INTEGER,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		    FOREIGN KEY (meal_id) REFERENCES meals (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS audit_log (
+		    id INTEGER PRIMARY KEY,
+		    actor_id INTEGER,
+		    impersonated_id INTEGER,
+		    action TEXT NOT NULL,
+		    target_user_id INTEGER,
+		    target_id INTEGER,
+		    details TEXT,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE SET NULL,
+		    FOREIGN KEY (impersonated_id) REFERENCES users (id) ON DELETE SET NULL,
+		    FOREIGN KEY (target_user_id) REFERENCES users (id) ON DELETE SET NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS change_log (
+		    id INTEGER PRIMARY KEY,
+		    actor_type TEXT NOT NULL CHECK (actor_type IN ('user', 'system', 'admin')),
+		    actor_id INTEGER,
+		    action TEXT NOT NULL,
+		    entity TEXT NOT NULL CHECK (entity IN ('meal', 'user', 'tag', 'comment')),
+		    entity_id INTEGER NOT NULL,
+		    owner_id INTEGER,
+		    diff TEXT NOT NULL,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
+		-- The change log is append-only. Entries only go when the account
+		-- owning them is deleted.
+		CREATE TRIGGER IF NOT EXISTS change_log_no_update BEFORE UPDATE ON change_log
+		BEGIN
+		    SELECT RAISE(ABORT, 'change_log is append-only');
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS change_log_no_delete BEFORE DELETE ON change_log
+		WHEN OLD.owner_id IS NULL OR EXISTS (SELECT 1 FROM users WHERE id = OLD.owner_id)
+		BEGIN
+		    SELECT RAISE(ABORT, 'change_log is append-only');
+		END;
+
+		CREATE TABLE IF NOT EXISTS recognition_failures (
+		    id INTEGER PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    meal_id INTEGER NOT NULL,
+		    error TEXT NOT NULL,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		    FOREIGN KEY (meal_id) REFERENCES meals (id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS meal_imports (
+		    id INTEGER PRIMARY KEY,
+		    user_id INTEGER NOT NULL,
+		    source TEXT NOT NULL,
+		    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+		    data BLOB,
+		    total INTEGER NOT NULL DEFAULT 0,
+		    processed INTEGER NOT NULL DEFAULT 0,
+		    imported INTEGER NOT NULL DEFAULT 0,
+		    error TEXT,
+		    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    started_at TIMESTAMP,
+		    completed_at TIMESTAMP,
+		    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		);
+
 		INSERT INTO tags (name) VALUES 
-		('Keto'), ('Breakfast'), ('Lunch'), ('Dinner'), ('Snack'), ('Vegetarian'), ('Vegan')
+		('Keto'), ('Vegetarian'), ('Vegan')
 		ON CONFLICT DO NOTHING;
 	`
 	_, err = db.Exec(createTables)
@@ -130,9 +358,145 @@ func NewStorage(dbFile string) (*storage, error) {
 		return nil, err
 	}
 
+	if err := migrateColumns(db); err != nil {
+		return nil, err
+	}
+
+	if err := migrateData(db); err != nil {
+		return nil, err
+	}
+
+	createIndexes := `
+		CREATE INDEX IF NOT EXISTS idx_meals_user_created ON meals (user_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_meals_user_eaten ON meals (user_id, eaten_at);
+		CREATE INDEX IF NOT EXISTS idx_meals_eaten ON meals (eaten_at);
+		CREATE INDEX IF NOT EXISTS idx_meals_deleted ON meals (deleted_at);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_meals_user_import ON meals (user_id, import_key);
+		CREATE INDEX IF NOT EXISTS idx_followers_followee ON followers (followee_id, follower_id);
+		CREATE INDEX IF NOT EXISTS idx_comments_meal ON comments (meal_id);
+		CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, created_at);
+		CREATE INDEX IF NOT EXISTS idx_reports_meal ON reports (meal_id);
+		CREATE INDEX IF NOT EXISTS idx_reports_comment ON reports (comment_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log (created_at);
+		CREATE INDEX IF NOT EXISTS idx_change_log_entity ON change_log (entity, entity_id);
+		CREATE INDEX IF NOT EXISTS idx_change_log_owner ON change_log (owner_id);
+		CREATE INDEX IF NOT EXISTS idx_recognition_failures_created ON recognition_failures (created_at);
+		CREATE INDEX IF NOT EXISTS idx_bot_updates_received ON bot_updates (received_at);
+		CREATE INDEX IF NOT EXISTS idx_photo_uploads_meal ON photo_uploads (meal_id);
+		CREATE INDEX IF NOT EXISTS idx_body_metrics_user_measured ON body_metrics (user_id, measured_at);
+		CREATE INDEX IF NOT EXISTS idx_beverages_user_consumed ON beverages (user_id, consumed_at);
+	`
+	_, err = db.Exec(createIndexes)
+	if err != nil {
+		return nil, err
+	}
+
 	return &storage{db: db}, nil
 }
 
+// columnMigrations lists columns added after a table was first created.
+// CREATE TABLE above already has them for new databases; existing databases
+// get them through ALTER TABLE.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"users", "timezone", "TEXT NOT NULL DEFAULT 'UTC'"},
+	{"users", "digest_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"users", "digest_time", "TEXT NOT NULL DEFAULT '21:00'"},
+	{"users", "water_target_ml", "INTEGER NOT NULL DEFAULT 2000"},
+	{"users", "default_visibility", "TEXT NOT NULL DEFAULT 'public' CHECK (default_visibility IN ('public', 'followers', 'private'))"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'))"},
+	{"users", "banned_at", "TIMESTAMP"},
+	{"meals", "eaten_at", "TIMESTAMP"},
+	{"meals", "meal_type", "TEXT CHECK (meal_type IN ('breakfast', 'lunch', 'dinner', 'snack'))"},
+	{"meals", "ai_ingredients", "TEXT"},
+	{"meals", "ai_food_insights", "TEXT"},
+	{"meals", "ingredients_edited_at", "TIMESTAMP"},
+	{"meals", "version", "INTEGER NOT NULL DEFAULT 1"},
+	{"meals", "deleted_at", "TIMESTAMP"},
+	{"meals", "import_key", "TEXT"},
+	{"meals", "thumbnail_url", "TEXT"},
+	{"meals", "visibility", "TEXT NOT NULL DEFAULT 'public' CHECK (visibility IN ('public', 'followers', 'private'))"},
+	{"meals", "removed_at", "TIMESTAMP"},
+	{"comments", "removed_at", "TIMESTAMP"},
+	{"photo_uploads", "meal_id", "INTEGER REFERENCES meals (id) ON DELETE CASCADE"},
+}
+
+func migrateColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", m.table, m.column).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	return nil
+}
+
+// dataMigrations backfill data for schema changes. Each statement must be
+// idempotent since they run on every start.
+var dataMigrations = []string{
+	// Meals logged before eaten_at existed were eaten when they were logged.
+	`UPDATE meals SET eaten_at = created_at WHERE eaten_at IS NULL`,
+
+	// Meal types used to be seeded as tags. Move them to meal_type and drop
+	// the tags, which cascades to meal_tags.
+	`UPDATE meals
+	 SET meal_type = (
+	     SELECT lower(t.name)
+	     FROM meal_tags mt
+	              JOIN tags t ON t.id = mt.tag_id
+	     WHERE mt.meal_id = meals.id AND t.name IN ('Breakfast', 'Lunch', 'Dinner', 'Snack')
+	     LIMIT 1)
+	 WHERE meal_type IS NULL
+	   AND EXISTS (
+	     SELECT 1
+	     FROM meal_tags mt
+	              JOIN tags t ON t.id = mt.tag_id
+	     WHERE mt.meal_id = meals.id AND t.name IN ('Breakfast', 'Lunch', 'Dinner', 'Snack'))`,
+	`DELETE FROM tags WHERE name IN ('Breakfast', 'Lunch', 'Dinner', 'Snack')`,
+
+	// Analyses stored before the AI estimate was kept separately are
+	// still unedited.
+	`UPDATE meals
+	 SET ai_ingredients = ingredients, ai_food_insights = food_insights
+	 WHERE ai_food_insights IS NULL AND food_insights IS NOT NULL AND ingredients_edited_at IS NULL`,
+
+	// Digest times used to be stored as given, without padding single
+	// digit hours.
+	`UPDATE users SET digest_time = '0' || digest_time WHERE length(digest_time) = 4`,
+
+	// Uploads used to be attachable to any number of meals. Bind those
+	// already in use to the first meal using them.
+	`UPDATE photo_uploads
+	 SET meal_id = (
+	     SELECT MIN(m.id)
+	     FROM meals m
+	     WHERE substr(m.photo_url, -length(photo_uploads.object_key) - 1) = '/' || photo_uploads.object_key)
+	 WHERE meal_id IS NULL`,
+}
+
+func migrateData(db *sql.DB) error {
+	for _, q := range dataMigrations {
+		if _, err := db.Exec(q); err != nil {
+			return fmt.Errorf("failed to migrate data: %w", err)
+		}
+	}
+
+	return nil
+}
+
 type HealthStats struct {
 	Status            string `json:"status"`
 	Error             string `json:"error,omitempty"`
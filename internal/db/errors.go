@@ -9,6 +9,8 @@ import (
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
+	ErrConflict      = errors.New("conflict")
+	ErrPhotoInUse    = errors.New("photo is attached to another meal")
 )
 
 func IsNoRowsError(err error) bool {
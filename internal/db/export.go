@@ -0,0 +1,191 @@
+package db
+
+import "time"
+
+// Statuses of background jobs such as data exports and meal imports.
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// jobStaleAfter is how long a background job may stay running before it is
+// considered abandoned, e.g. by a restart, and picked up again.
+const jobStaleAfter = 30 * time.Minute
+
+// DataExport is a background job that builds an archive of a user's data.
+// ObjectKey is set once the archive is uploaded.
+type DataExport struct {
+	ID          int64      `db:"id" json:"id"`
+	UserID      int64      `db:"user_id" json:"-"`
+	Status      string     `db:"status" json:"status"`
+	ObjectKey   *string    `db:"object_key" json:"-"`
+	Error       *string    `db:"error" json:"error"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at"`
+}
+
+const dataExportColumns = "id, user_id, status, object_key, error, created_at, completed_at"
+
+func scanDataExport(row interface{ Scan(...interface{}) error }, e *DataExport) error {
+	return row.Scan(&e.ID, &e.UserID, &e.Status, &e.ObjectKey, &e.Error, &e.CreatedAt, &e.CompletedAt)
+}
+
+func (s *storage) CreateDataExport(uid int64) (*DataExport, error) {
+	res, err := s.db.Exec("INSERT INTO data_exports (user_id) VALUES (?)", uid)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetDataExport(uid, id)
+}
+
+func (s *storage) GetDataExport(uid, id int64) (*DataExport, error) {
+	var e DataExport
+
+	row := s.db.QueryRow("SELECT "+dataExportColumns+" FROM data_exports WHERE id = ? AND user_id = ?", id, uid)
+
+	if err := scanDataExport(row, &e); err != nil && IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// ListPendingDataExports returns exports that are waiting to be built or
+// whose build was abandoned.
+func (s *storage) ListPendingDataExports() ([]DataExport, error) {
+	var exports []DataExport
+
+	q := "SELECT " + dataExportColumns + ` FROM data_exports
+		WHERE status = ? OR (status = ? AND started_at <= ?)
+		ORDER BY id`
+
+	rows, err := s.db.Query(q, JobStatusPending, JobStatusRunning, formatTimestamp(time.Now().Add(-jobStaleAfter)))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e DataExport
+		if err := scanDataExport(rows, &e); err != nil {
+			return nil, err
+		}
+
+		exports = append(exports, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return exports, nil
+}
+
+// ClaimDataExport marks a pending or abandoned export as running. It returns
+// false when another worker already has it.
+func (s *storage) ClaimDataExport(id int64) (bool, error) {
+	q := `
+		UPDATE data_exports
+		SET status = ?, started_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (status = ? OR (status = ? AND started_at <= ?))
+	`
+
+	res, err := s.db.Exec(q, JobStatusRunning, id, JobStatusPending, JobStatusRunning, formatTimestamp(time.Now().Add(-jobStaleAfter)))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// FinishDataExport records the uploaded archive, or the error that stopped
+// the export when exportErr is set.
+func (s *storage) FinishDataExport(id int64, objectKey string, exportErr error) error {
+	status, key, errText := JobStatusDone, &objectKey, (*string)(nil)
+	if exportErr != nil {
+		msg := exportErr.Error()
+		status, key, errText = JobStatusFailed, nil, &msg
+	}
+
+	q := `
+		UPDATE data_exports
+		SET status = ?, object_key = ?, error = ?, completed_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	_, err := s.db.Exec(q, status, key, errText, id)
+
+	return err
+}
+
+// ListUserMeals returns every meal of the user that is not deleted,
+// including ones still waiting for recognition.
+func (s *storage) ListUserMeals(uid int64) ([]Meal, error) {
+	var meals []Meal
+
+	q := `
+		SELECT id, user_id, text, created_at, updated_at, hidden_at, photo_url, thumbnail_url, dish_name, ingredients,
+		       is_spam, food_insights, aesthetic_rating, health_rating, eaten_at, meal_type,
+		       ingredients_edited_at, version
+		FROM meals
+		WHERE user_id = ? AND deleted_at IS NULL
+		ORDER BY eaten_at
+	`
+
+	rows, err := s.db.Query(q, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Meal
+		if err := rows.Scan(
+			&m.ID,
+			&m.UserID,
+			&m.Text,
+			&m.CreatedAt,
+			&m.UpdatedAt,
+			&m.HiddenAt,
+			&m.PhotoURL,
+			&m.ThumbnailURL,
+			&m.DishName,
+			&m.Ingredients,
+			&m.IsSpam,
+			&m.FoodInsights,
+			&m.AestheticRating,
+			&m.HealthRating,
+			&m.EatenAt,
+			&m.MealType,
+			&m.IngredientsEditedAt,
+			&m.Version,
+		); err != nil {
+			return nil, err
+		}
+
+		meals = append(meals, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return meals, nil
+}
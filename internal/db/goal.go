@@ -0,0 +1,133 @@
+package db
+
+import "time"
+
+const (
+	GoalSourceManual     = "manual"
+	GoalSourceCalculated = "calculated"
+)
+
+// Goal is a daily nutrition target. Goals are versioned by EffectiveFrom, a
+// local date: a day is judged against the latest version that started on or
+// before it. Within a version a row with Weekday set (0 is Sunday) overrides
+// the default row, which has no weekday.
+type Goal struct {
+	ID            int64     `db:"id" json:"-"`
+	UserID        int64     `db:"user_id" json:"-"`
+	Weekday       *int      `db:"weekday" json:"weekday"`
+	Calories      int       `db:"calories" json:"calories"`
+	Proteins      int       `db:"proteins" json:"proteins"`
+	Fats          int       `db:"fats" json:"fats"`
+	Carbohydrates int       `db:"carbohydrates" json:"carbohydrates"`
+	Source        string    `db:"source" json:"source"`
+	EffectiveFrom string    `db:"effective_from" json:"effective_from"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+}
+
+// SetGoals replaces the goal version starting at effectiveFrom with goals.
+func (s *storage) SetGoals(uid int64, effectiveFrom string, goals []Goal) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_goals WHERE user_id = ? AND effective_from = ?", uid, effectiveFrom); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	q := `
+		INSERT INTO user_goals (user_id, weekday, calories, proteins, fats, carbohydrates, source, effective_from)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	for _, g := range goals {
+		if _, err := tx.Exec(q, uid, g.Weekday, g.Calories, g.Proteins, g.Fats, g.Carbohydrates, g.Source, effectiveFrom); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetGoalForDate returns the goal in force on a local date with the given weekday.
+func (s *storage) GetGoalForDate(uid int64, date string, weekday time.Weekday) (*Goal, error) {
+	var g Goal
+
+	q := `
+		SELECT id, user_id, weekday, calories, proteins, fats, carbohydrates, source, effective_from, created_at
+		FROM user_goals
+		WHERE user_id = ?
+		  AND effective_from = (SELECT MAX(effective_from) FROM user_goals WHERE user_id = ? AND effective_from <= ?)
+		  AND (weekday IS NULL OR weekday = ?)
+		ORDER BY weekday IS NULL
+		LIMIT 1
+	`
+
+	err := s.db.QueryRow(q, uid, uid, date, int(weekday)).Scan(
+		&g.ID,
+		&g.UserID,
+		&g.Weekday,
+		&g.Calories,
+		&g.Proteins,
+		&g.Fats,
+		&g.Carbohydrates,
+		&g.Source,
+		&g.EffectiveFrom,
+		&g.CreatedAt,
+	)
+
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// ListGoals returns every goal version of the user, newest first.
+func (s *storage) ListGoals(uid int64) ([]Goal, error) {
+	var goals []Goal
+
+	q := `
+		SELECT id, user_id, weekday, calories, proteins, fats, carbohydrates, source, effective_from, created_at
+		FROM user_goals
+		WHERE user_id = ?
+		ORDER BY effective_from DESC, weekday IS NOT NULL, weekday
+	`
+
+	rows, err := s.db.Query(q, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var g Goal
+		if err := rows.Scan(
+			&g.ID,
+			&g.UserID,
+			&g.Weekday,
+			&g.Calories,
+			&g.Proteins,
+			&g.Fats,
+			&g.Carbohydrates,
+			&g.Source,
+			&g.EffectiveFrom,
+			&g.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		goals = append(goals, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
@@ -0,0 +1,183 @@
+package db
+
+import "time"
+
+// MealImport is a background job that imports meal history exported from
+// another tracker. Total, Processed and Imported report its progress; meals
+// that were processed but not imported were already there.
+type MealImport struct {
+	ID          int64      `db:"id" json:"id"`
+	UserID      int64      `db:"user_id" json:"-"`
+	Source      string     `db:"source" json:"source"`
+	Status      string     `db:"status" json:"status"`
+	Total       int        `db:"total" json:"total"`
+	Processed   int        `db:"processed" json:"processed"`
+	Imported    int        `db:"imported" json:"imported"`
+	Error       *string    `db:"error" json:"error"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at"`
+}
+
+const mealImportColumns = "id, user_id, source, status, total, processed, imported, error, created_at, completed_at"
+
+func scanMealImport(row interface{ Scan(...interface{}) error }, i *MealImport) error {
+	return row.Scan(&i.ID, &i.UserID, &i.Source, &i.Status, &i.Total, &i.Processed, &i.Imported, &i.Error, &i.CreatedAt, &i.CompletedAt)
+}
+
+// CreateMealImport queues an import of data, an export file of source.
+func (s *storage) CreateMealImport(uid int64, source string, data []byte) (*MealImport, error) {
+	res, err := s.db.Exec("INSERT INTO meal_imports (user_id, source, data) VALUES (?, ?, ?)", uid, source, data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetMealImport(uid, id)
+}
+
+func (s *storage) GetMealImport(uid, id int64) (*MealImport, error) {
+	var i MealImport
+
+	row := s.db.QueryRow("SELECT "+mealImportColumns+" FROM meal_imports WHERE id = ? AND user_id = ?", id, uid)
+
+	if err := scanMealImport(row, &i); err != nil && IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// ListPendingMealImports returns imports that are waiting to run or whose
+// run was abandoned.
+func (s *storage) ListPendingMealImports() ([]MealImport, error) {
+	var imports []MealImport
+
+	q := "SELECT " + mealImportColumns + ` FROM meal_imports
+		WHERE status = ? OR (status = ? AND started_at <= ?)
+		ORDER BY id`
+
+	rows, err := s.db.Query(q, JobStatusPending, JobStatusRunning, formatTimestamp(time.Now().Add(-jobStaleAfter)))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i MealImport
+		if err := scanMealImport(rows, &i); err != nil {
+			return nil, err
+		}
+
+		imports = append(imports, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return imports, nil
+}
+
+// ClaimMealImport marks a pending or abandoned import as running and returns
+// its file. It returns ErrNotFound when another worker already has it.
+func (s *storage) ClaimMealImport(id int64) ([]byte, error) {
+	q := `
+		UPDATE meal_imports
+		SET status = ?, started_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (status = ? OR (status = ? AND started_at <= ?))
+		RETURNING data
+	`
+
+	var data []byte
+
+	err := s.db.QueryRow(q, JobStatusRunning, id, JobStatusPending, JobStatusRunning, formatTimestamp(time.Now().Add(-jobStaleAfter))).Scan(&data)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *storage) UpdateMealImportProgress(id int64, total, processed, imported int) error {
+	_, err := s.db.Exec("UPDATE meal_imports SET total = ?, processed = ?, imported = ? WHERE id = ?", total, processed, imported, id)
+
+	return err
+}
+
+// FinishMealImport marks an import done, or failed with importErr, and drops
+// its file.
+func (s *storage) FinishMealImport(id int64, importErr error) error {
+	status, errText := JobStatusDone, (*string)(nil)
+	if importErr != nil {
+		msg := importErr.Error()
+		status, errText = JobStatusFailed, &msg
+	}
+
+	q := `
+		UPDATE meal_imports
+		SET status = ?, error = ?, data = NULL, completed_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	_, err := s.db.Exec(q, status, errText, id)
+
+	return err
+}
+
+// ImportMeals stores meals brought in from another tracker. They are shown
+// to their owner right away since they skip recognition, but are private:
+// years of photo-less history do not belong on the feed. Meals whose
+// ImportKey the user already has are skipped; the meals actually inserted
+// are returned.
+func (s *storage) ImportMeals(uid int64, meals []Meal) ([]Meal, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+		INSERT INTO meals (user_id, photo_url, text, dish_name, ingredients, ai_ingredients, food_insights, ai_food_insights,
+		                   eaten_at, meal_type, import_key, visibility)
+		VALUES (?, '', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, import_key) DO NOTHING
+	`
+
+	var imported []Meal
+
+	for _, m := range meals {
+		res, err := tx.Exec(q, uid, m.Text, m.DishName, m.Ingredients, m.Ingredients, m.FoodInsights, m.FoodInsights,
+			formatTimestamp(m.EatenAt), m.MealType, m.ImportKey, MealVisibilityPrivate)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			continue
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		m.ID, m.UserID, m.Visibility = id, uid, MealVisibilityPrivate
+		imported = append(imported, m)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return imported, nil
+}
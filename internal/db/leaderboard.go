@@ -0,0 +1,162 @@
+package db
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	LeaderboardAesthetic = "aesthetic"
+	LeaderboardHealth    = "health"
+	LeaderboardStreak    = "streak"
+
+	LeaderboardWeek  = "week"
+	LeaderboardMonth = "month"
+)
+
+// minHealthMeals is how many rated meals a user needs in a period to be
+// ranked by their average health rating.
+const minHealthMeals = 3
+
+// LeaderboardEntry is a user's score on a leaderboard. MealID is the
+// user's best-looking meal on the aesthetic board.
+type LeaderboardEntry struct {
+	Board       string    `db:"board"`
+	Period      string    `db:"period"`
+	PeriodStart string    `db:"period_start"`
+	UserID      int64     `db:"user_id"`
+	MealID      *int64    `db:"meal_id"`
+	Score       float64   `db:"score"`
+	Meals       int       `db:"meals"`
+	ComputedAt  time.Time `db:"computed_at"`
+
+	// Meal is set for entries with a meal.
+	Meal *Meal `db:"-"`
+}
+
+// leaderboardQueries compute each board for meals eaten in [start, end),
+// the only two arguments. Spam, removed and deleted meals and the meals of
+// banned users never count. The aesthetic board shows the meals themselves,
+// so only public meals take part. The other boards are shown to followers
+// and use the meals they may see.
+var leaderboardQueries = map[string]string{
+	LeaderboardAesthetic: `
+		SELECT user_id, id, aesthetic_rating, 1
+		FROM (SELECT m.user_id, m.id, m.aesthetic_rating,
+		             ROW_NUMBER() OVER (PARTITION BY m.user_id ORDER BY m.aesthetic_rating DESC, m.eaten_at) AS rn
+		      FROM meals m
+		               JOIN users u ON u.id = m.user_id
+		      WHERE m.eaten_at >= ? AND m.eaten_at < ? AND m.deleted_at IS NULL AND m.is_spam = FALSE AND m.removed_at IS NULL
+		        AND u.banned_at IS NULL AND m.aesthetic_rating IS NOT NULL AND m.visibility = 'public')
+		WHERE rn = 1`,
+	LeaderboardHealth: `
+		SELECT m.user_id, NULL, AVG(m.health_rating), COUNT(*)
+		FROM meals m
+		         JOIN users u ON u.id = m.user_id
+		WHERE m.eaten_at >= ? AND m.eaten_at < ? AND m.deleted_at IS NULL AND m.is_spam = FALSE AND m.removed_at IS NULL
+		  AND u.banned_at IS NULL AND m.health_rating IS NOT NULL AND m.visibility IN ('public', 'followers')
+		GROUP BY m.user_id
+		HAVING COUNT(*) >= ` + strconv.Itoa(minHealthMeals),
+	// Days in a run of consecutive days are all the same number of days
+	// after their row number, which groups each run.
+	LeaderboardStreak: `
+		WITH days AS (SELECT DISTINCT m.user_id, date(m.eaten_at) AS day
+		              FROM meals m
+		                       JOIN users u ON u.id = m.user_id
+		              WHERE m.eaten_at >= ? AND m.eaten_at < ? AND m.deleted_at IS NULL AND m.is_spam = FALSE AND m.removed_at IS NULL
+		                AND u.banned_at IS NULL AND m.visibility IN ('public', 'followers')),
+		     runs AS (SELECT user_id, COUNT(*) AS length
+		              FROM (SELECT user_id, julianday(day) - ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY day) AS run
+		                    FROM days)
+		              GROUP BY user_id, run)
+		SELECT user_id, NULL, MAX(length), SUM(length)
+		FROM runs
+		GROUP BY user_id`,
+}
+
+// RefreshLeaderboards recomputes every board for the period starting at
+// start and ending before end, replacing the previous results.
+func (s *storage) RefreshLeaderboards(period string, start, end time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	periodStart := start.Format("2006-01-02")
+
+	if _, err := tx.Exec("DELETE FROM leaderboard_entries WHERE period = ? AND period_start = ?", period, periodStart); err != nil {
+		return err
+	}
+
+	for board, query := range leaderboardQueries {
+		q := `
+			INSERT INTO leaderboard_entries (board, period, period_start, user_id, meal_id, score, meals)
+			SELECT ?, ?, ?, * FROM (` + query + `)
+		`
+
+		if _, err := tx.Exec(q, board, period, periodStart, formatTimestamp(start), formatTimestamp(end)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListLeaderboard returns the top entries of a board for a period, best
+// first. Entries of the health and streak boards are limited to the viewer
+// and the users they follow. Entries whose meal is no longer public, was
+// flagged as spam or removed since, and entries of banned users are skipped.
+func (s *storage) ListLeaderboard(viewerID int64, board, period string, periodStart time.Time, limit int) ([]LeaderboardEntry, error) {
+	var entries []LeaderboardEntry
+
+	q := `
+		SELECT e.board, e.period, e.period_start, e.user_id, e.meal_id, e.score, e.meals, e.computed_at,
+		       m.dish_name, m.photo_url, m.thumbnail_url
+		FROM leaderboard_entries e
+				 JOIN users u ON u.id = e.user_id
+				 LEFT JOIN meals m ON m.id = e.meal_id
+		WHERE e.board = ? AND e.period = ? AND e.period_start = ? AND u.banned_at IS NULL
+		  AND (e.meal_id IS NULL OR (m.deleted_at IS NULL AND m.is_spam = FALSE AND m.removed_at IS NULL AND m.visibility = 'public'))
+		  AND (e.board = 'aesthetic' OR e.user_id = ?
+		       OR e.user_id IN (SELECT f.followee_id FROM followers f WHERE f.follower_id = ?))
+		ORDER BY e.score DESC, e.meals DESC, e.user_id
+		LIMIT ?
+	`
+
+	rows, err := s.db.Query(q, board, period, periodStart.Format("2006-01-02"), viewerID, viewerID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e LeaderboardEntry
+		var dishName, photoURL, thumbnailURL *string
+
+		if err := rows.Scan(&e.Board, &e.Period, &e.PeriodStart, &e.UserID, &e.MealID, &e.Score, &e.Meals, &e.ComputedAt,
+			&dishName, &photoURL, &thumbnailURL); err != nil {
+			return nil, err
+		}
+
+		if e.MealID != nil && photoURL != nil {
+			e.Meal = &Meal{
+				ID:           *e.MealID,
+				UserID:       e.UserID,
+				DishName:     dishName,
+				PhotoURL:     *photoURL,
+				ThumbnailURL: thumbnailURL,
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
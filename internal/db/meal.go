@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 )
@@ -16,6 +17,7 @@ type Meal struct {
 	UpdatedAt       time.Time     `db:"updated_at" json:"updated_at"`
 	HiddenAt        *time.Time    `db:"hidden_at" json:"hidden_at"`
 	PhotoURL        string        `db:"photo_url" json:"photo_url"`
+	ThumbnailURL    *string       `db:"thumbnail_url" json:"thumbnail_url"`
 	DishName        *string       `json:"dish_name" db:"dish_name"`
 	HealthRating    *int          `json:"health_rating" db:"health_rating"`
 	AestheticRating *int          `json:"aesthetic_rating" db:"aesthetic_rating"`
@@ -23,8 +25,59 @@ type Meal struct {
 	Tags            ArrayString   `json:"tags" db:"tags"`
 	IsSpam          bool          `json:"is_spam" db:"is_spam"`
 	FoodInsights    *FoodInsights `json:"food_insights" db:"food_insights"`
+	EatenAt         time.Time     `json:"eaten_at" db:"eaten_at"`
+	MealType        *string       `json:"meal_type" db:"meal_type"`
+
+	// IngredientsEditedAt is set once the owner corrects the AI estimate.
+	IngredientsEditedAt *time.Time `json:"ingredients_edited_at" db:"ingredients_edited_at"`
+
+	// Version is bumped on every change and guards UpdateMeal against
+	// overwriting changes the caller has not seen.
+	Version int `json:"version" db:"version"`
+
+	DeletedAt *time.Time `json:"-" db:"deleted_at"`
+
+	// ImportKey identifies a meal imported from another tracker.
+	ImportKey *string `json:"-" db:"import_key"`
+
+	// Visibility decides who besides the owner sees the meal: everyone,
+	// the owner's followers, or no one.
+	Visibility string `json:"visibility" db:"visibility"`
+
+	// RemovedAt is set when a moderator removes the meal. Like spam, a
+	// removed meal is only shown to its owner.
+	RemovedAt *time.Time `json:"removed_at" db:"removed_at"`
+
+	// Reactions and MyReaction are as seen by the user the meal was read
+	// for. MyReaction is nil when they have not reacted.
+	Reactions  ReactionCounts `json:"reactions"`
+	MyReaction *string        `json:"my_reaction"`
 }
 
+const (
+	MealTypeBreakfast = "breakfast"
+	MealTypeLunch     = "lunch"
+	MealTypeDinner    = "dinner"
+	MealTypeSnack     = "snack"
+)
+
+const (
+	MealVisibilityPublic    = "public"
+	MealVisibilityFollowers = "followers"
+	MealVisibilityPrivate   = "private"
+)
+
+// visibleMeal is a condition on meals aliased m that holds for the meals a
+// viewer may see: their own, public ones and followers-only ones of users
+// they follow. Spam, removed meals and meals of banned users are only seen
+// by their owner. Every query returning meals to someone other than their
+// owner must include it, with the viewer's ID as both arguments.
+const visibleMeal = `(m.user_id = ? OR (m.is_spam = FALSE AND m.removed_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM users b WHERE b.id = m.user_id AND b.banned_at IS NOT NULL)
+	AND (m.visibility = 'public'
+	     OR (m.visibility = 'followers' AND EXISTS (
+	         SELECT 1 FROM followers f WHERE f.followee_id = m.user_id AND f.follower_id = ?)))))`
+
 type FoodInsights struct {
 	Calories      int `json:"calories" db:"calories"`
 	Proteins      int `json:"proteins" db:"proteins"`
@@ -75,6 +128,43 @@ type Ingredient struct {
 
 type Ingredients []Ingredient
 
+// Scaled returns the ingredient at a new weight with calories and macros
+// changed in proportion.
+func (i Ingredient) Scaled(weight float64) Ingredient {
+	if i.Weight <= 0 {
+		i.Weight = weight
+		return i
+	}
+
+	factor := weight / i.Weight
+
+	i.Weight = weight
+	i.Calories *= factor
+	i.Macros.Proteins *= factor
+	i.Macros.Fats *= factor
+	i.Macros.Carbohydrates *= factor
+
+	return i
+}
+
+// Totals sums calories and macros of all ingredients.
+func (i Ingredients) Totals() FoodInsights {
+	var calories, proteins, fats, carbohydrates float64
+	for _, ingredient := range i {
+		calories += ingredient.Calories
+		proteins += ingredient.Macros.Proteins
+		fats += ingredient.Macros.Fats
+		carbohydrates += ingredient.Macros.Carbohydrates
+	}
+
+	return FoodInsights{
+		Calories:      int(math.Round(calories)),
+		Proteins:      int(math.Round(proteins)),
+		Fats:          int(math.Round(fats)),
+		Carbohydrates: int(math.Round(carbohydrates)),
+	}
+}
+
 func (i *Ingredients) Scan(src interface{}) error {
 	var source []byte
 	switch src := src.(type) {
@@ -152,6 +242,18 @@ func (ts *TagSlice) Scan(src interface{}) error {
 }
 
 func (s *storage) GetMealByID(id int64) (*Meal, error) {
+	return s.getMeal(0, "m.id = ?", id)
+}
+
+// GetVisibleMeal returns a meal if viewer may see it and ErrNotFound
+// otherwise, so that private meals cannot be told apart from missing ones.
+func (s *storage) GetVisibleMeal(viewerID, id int64) (*Meal, error) {
+	return s.getMeal(viewerID, "m.id = ? AND "+visibleMeal, id, viewerID, viewerID)
+}
+
+// getMeal returns the meal matching where, which takes args, with reactions
+// as seen by viewer.
+func (s *storage) getMeal(viewerID int64, where string, args ...interface{}) (*Meal, error) {
 	var meal Meal
 
 	query := `
@@ -162,16 +264,27 @@ func (s *storage) GetMealByID(id int64) (*Meal, error) {
 			   m.updated_at,
 			   m.hidden_at,
 			   m.photo_url,
+			   m.thumbnail_url,
 			   m.dish_name,
 			   m.ingredients,
 			   m.tags,
 			   m.is_spam,
-			   m.food_insights
+			   m.food_insights,
+			   m.aesthetic_rating,
+			   m.health_rating,
+			   m.eaten_at,
+			   m.meal_type,
+			   m.ingredients_edited_at,
+			   m.version,
+			   m.visibility,
+			   m.removed_at,
+			   ` + reactionColumns + `
 		FROM meals m
-		WHERE m.id = ?
-	`
+		WHERE m.deleted_at IS NULL AND ` + where
 
-	err := s.db.QueryRow(query, id).Scan(
+	args = append([]interface{}{viewerID}, args...)
+
+	err := s.db.QueryRow(query, args...).Scan(
 		&meal.ID,
 		&meal.UserID,
 		&meal.Text,
@@ -179,11 +292,22 @@ func (s *storage) GetMealByID(id int64) (*Meal, error) {
 		&meal.UpdatedAt,
 		&meal.HiddenAt,
 		&meal.PhotoURL,
+		&meal.ThumbnailURL,
 		&meal.DishName,
 		&meal.Ingredients,
 		&meal.Tags,
 		&meal.IsSpam,
 		&meal.FoodInsights,
+		&meal.AestheticRating,
+		&meal.HealthRating,
+		&meal.EatenAt,
+		&meal.MealType,
+		&meal.IngredientsEditedAt,
+		&meal.Version,
+		&meal.Visibility,
+		&meal.RemovedAt,
+		&meal.Reactions,
+		&meal.MyReaction,
 	)
 
 	if IsNoRowsError(err) {
@@ -195,20 +319,43 @@ func (s *storage) GetMealByID(id int64) (*Meal, error) {
 	return &meal, nil
 }
 
-func (s *storage) AddMeal(uid int64, meal Meal) (*Meal, error) {
+// AddMeal stores a new meal with the photo of the user's confirmed upload
+// photoKey. Without a visibility it gets the user's default visibility. It
+// returns ErrPhotoInUse when the upload belongs to another meal.
+func (s *storage) AddMeal(uid int64, meal Meal, photoKey string) (*Meal, error) {
 	mealQuery := `
-        INSERT INTO meals (user_id, photo_url, hidden_at, text)
-        VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+        INSERT INTO meals (user_id, photo_url, thumbnail_url, hidden_at, text, eaten_at, meal_type, visibility)
+        VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?, COALESCE(NULLIF(?, ''), (SELECT default_visibility FROM users WHERE id = ?)))
     `
 
-	res, err := s.db.Exec(mealQuery, uid, meal.PhotoURL, meal.Text)
+	if meal.EatenAt.IsZero() {
+		meal.EatenAt = time.Now()
+	}
+
+	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 
+	res, err := tx.Exec(mealQuery, uid, meal.PhotoURL, meal.ThumbnailURL, meal.Text, formatTimestamp(meal.EatenAt), meal.MealType, meal.Visibility, uid)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	id, err := res.LastInsertId()
 
 	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := attachPhotoUpload(tx, uid, id, photoKey); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
@@ -217,9 +364,23 @@ func (s *storage) AddMeal(uid int64, meal Meal) (*Meal, error) {
 	return s.GetMealByID(id)
 }
 
-func (s *storage) ListMeals(startDate, endDate time.Time) ([]Meal, error) {
+// ListMeals returns the feed of meals eaten in the range that viewer may see.
+func (s *storage) ListMeals(viewerID int64, startDate, endDate time.Time) ([]Meal, error) {
+	return s.listMeals(viewerID, visibleMeal, startDate, endDate, viewerID, viewerID)
+}
+
+// ListProfileMeals returns the meals of a user eaten in the range that
+// viewer may see.
+func (s *storage) ListProfileMeals(viewerID, uid int64, startDate, endDate time.Time) ([]Meal, error) {
+	return s.listMeals(viewerID, "m.user_id = ? AND "+visibleMeal, startDate, endDate, uid, viewerID, viewerID)
+}
+
+// listMeals returns meals eaten in the range that match where, which takes
+// args, with reactions as seen by viewer. Every caller must restrict the
+// result with visibleMeal.
+func (s *storage) listMeals(viewerID int64, where string, startDate, endDate time.Time, args ...interface{}) ([]Meal, error) {
 	var meals []Meal
-	args := []interface{}{startDate, endDate}
+	args = append([]interface{}{viewerID, formatTimestamp(startDate), formatTimestamp(endDate)}, args...)
 
 	query := `
 		SELECT m.id,
@@ -229,6 +390,7 @@ func (s *storage) ListMeals(startDate, endDate time.Time) ([]Meal, error) {
 			   m.updated_at,
 			   m.hidden_at,
 			   m.photo_url,
+			   m.thumbnail_url,
 			   m.dish_name,
 			   m.ingredients,
 			   m.tags,
@@ -236,14 +398,21 @@ func (s *storage) ListMeals(startDate, endDate time.Time) ([]Meal, error) {
 			   m.food_insights,
 			   m.aesthetic_rating,
 			   m.health_rating,
+			   m.eaten_at,
+			   m.meal_type,
+			   m.ingredients_edited_at,
+			   m.version,
+			   m.visibility,
+			   m.removed_at,
+			   ` + reactionColumns + `,
 			   json_group_array(distinct json_object('id', t.id, 'name', t.name)) filter ( where t.id is not null) AS tags
 		FROM meals m
 				 JOIN users u ON m.user_id = u.id
 				 LEFT JOIN meal_tags pt ON m.id = pt.meal_id
 				 LEFT JOIN tags t ON pt.tag_id = t.id
-		WHERE m.created_at >= ? AND m.created_at <= ?
+		WHERE m.eaten_at >= ? AND m.eaten_at < ? AND m.deleted_at IS NULL AND ` + where + `
 		GROUP BY m.id
-		ORDER BY m.created_at DESC
+		ORDER BY m.eaten_at DESC
 	`
 
 	rows, err := s.db.Query(query, args...)
@@ -262,6 +431,7 @@ func (s *storage) ListMeals(startDate, endDate time.Time) ([]Meal, error) {
 			&m.UpdatedAt,
 			&m.HiddenAt,
 			&m.PhotoURL,
+			&m.ThumbnailURL,
 			&m.DishName,
 			&m.Ingredients,
 			&m.Tags,
@@ -269,6 +439,14 @@ func (s *storage) ListMeals(startDate, endDate time.Time) ([]Meal, error) {
 			&m.FoodInsights,
 			&m.AestheticRating,
 			&m.HealthRating,
+			&m.EatenAt,
+			&m.MealType,
+			&m.IngredientsEditedAt,
+			&m.Version,
+			&m.Visibility,
+			&m.RemovedAt,
+			&m.Reactions,
+			&m.MyReaction,
 			&m.Tags,
 		); err != nil {
 			return nil, err
@@ -321,26 +499,151 @@ func (s *storage) ListTags() ([]Tag, error) {
 	return tags, nil
 }
 
-func (s *storage) UpdateMeal(uid, mealID int64, meal Meal, tags []int) (*Meal, error) {
+// CreateTag adds a tag to the vocabulary. It returns ErrAlreadyExists when
+// the name is taken.
+func (s *storage) CreateTag(name string) (*Tag, error) {
+	var t Tag
+
+	err := s.db.QueryRow("INSERT INTO tags (name) VALUES (?) RETURNING id, name", name).Scan(&t.ID, &t.Name)
+	if IsDuplicateError(err) {
+		return nil, ErrAlreadyExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// RenameTag renames a tag, which meals keep. It returns ErrAlreadyExists
+// when the name is taken.
+func (s *storage) RenameTag(id int64, name string) (*Tag, error) {
+	var t Tag
+
+	err := s.db.QueryRow("UPDATE tags SET name = ? WHERE id = ? RETURNING id, name", name, id).Scan(&t.ID, &t.Name)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if IsDuplicateError(err) {
+		return nil, ErrAlreadyExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// DeleteTag removes a tag from the vocabulary and from meals.
+func (s *storage) DeleteTag(id int64) error {
+	res, err := s.db.Exec("DELETE FROM tags WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// MealUpdate is a partial update of the details the owner entered. Nil
+// fields are left unchanged. An empty Text clears the caption and a non-nil
+// empty Tags removes all tags. ThumbnailURL is written along with PhotoURL,
+// which must come with PhotoKey, the confirmed upload it is stored under.
+// ReplacedObjects are queued for deletion with the update.
+type MealUpdate struct {
+	Text            *string
+	PhotoURL        *string
+	ThumbnailURL    *string
+	PhotoKey        string
+	EatenAt         *time.Time
+	MealType        *string
+	Visibility      *string
+	Tags            []int
+	ReplacedObjects []ObjectDeletion
+}
+
+// UpdateMeal applies update if the meal is still at version. It returns
+// ErrNotFound when the user has no such meal, ErrConflict when the meal
+// was changed since version was read and ErrPhotoInUse when the new photo
+// belongs to another meal. Recognition results are stored with
+// SaveMealAnalysis and corrected with SetMealIngredients.
+func (s *storage) UpdateMeal(uid, mealID int64, version int, update MealUpdate) (*Meal, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 
-	updateQuery := `
-        UPDATE meals
-        SET text = ?, photo_url = ?, updated_at = CURRENT_TIMESTAMP, hidden_at = NULL,
-            dish_name = ?, ingredients = ?, is_spam = ?, food_insights = ?, aesthetic_rating = ?, health_rating = ?
-        WHERE id = ? AND user_id = ?
-    `
+	sets := []string{"updated_at = CURRENT_TIMESTAMP", "version = version + 1"}
+	var args []interface{}
+
+	if update.Text != nil {
+		sets = append(sets, "text = NULLIF(?, '')")
+		args = append(args, *update.Text)
+	}
+
+	if update.PhotoURL != nil {
+		sets = append(sets, "photo_url = ?", "thumbnail_url = ?")
+		args = append(args, *update.PhotoURL, update.ThumbnailURL)
+	}
+
+	if update.EatenAt != nil {
+		sets = append(sets, "eaten_at = ?")
+		args = append(args, formatTimestamp(*update.EatenAt))
+	}
 
-	_, err = tx.Exec(updateQuery, meal.Text, meal.PhotoURL, meal.DishName, meal.Ingredients, meal.IsSpam, meal.FoodInsights, meal.AestheticRating, meal.HealthRating, mealID, uid)
+	if update.MealType != nil {
+		sets = append(sets, "meal_type = ?")
+		args = append(args, *update.MealType)
+	}
+
+	if update.Visibility != nil {
+		sets = append(sets, "visibility = ?")
+		args = append(args, *update.Visibility)
+	}
+
+	updateQuery := fmt.Sprintf("UPDATE meals SET %s WHERE id = ? AND user_id = ? AND version = ? AND deleted_at IS NULL", strings.Join(sets, ", "))
+	args = append(args, mealID, uid, version)
+
+	res, err := tx.Exec(updateQuery, args...)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
 	}
 
-	if len(tags) > 0 {
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+
+		var owner int64
+		err := s.db.QueryRow("SELECT user_id FROM meals WHERE id = ? AND deleted_at IS NULL", mealID).Scan(&owner)
+		if IsNoRowsError(err) || (err == nil && owner != uid) {
+			return nil, ErrNotFound
+		} else if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrConflict
+	}
+
+	if update.PhotoURL != nil {
+		if err := attachPhotoUpload(tx, uid, mealID, update.PhotoKey); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if _, err := tx.Exec("DELETE FROM photo_uploads WHERE meal_id = ? AND object_key != ?", mealID, update.PhotoKey); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, o := range update.ReplacedObjects {
+		if _, err := tx.Exec("INSERT INTO object_deletions (object_key, is_prefix) VALUES (?, ?)", o.Key, o.IsPrefix); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if update.Tags != nil {
 		deleteQuery := `
             DELETE FROM meal_tags
             WHERE meal_id = ?
@@ -357,7 +660,7 @@ func (s *storage) UpdateMeal(uid, mealID int64, meal Meal, tags []int) (*Meal, e
             VALUES (?, ?)
         `
 
-		for _, tag := range tags {
+		for _, tag := range update.Tags {
 			_, err = tx.Exec(tagQuery, mealID, tag)
 			if err != nil {
 				tx.Rollback()
@@ -372,3 +675,353 @@ func (s *storage) UpdateMeal(uid, mealID int64, meal Meal, tags []int) (*Meal, e
 
 	return s.GetMealByID(mealID)
 }
+
+// timestampFormat matches the layout SQLite uses for CURRENT_TIMESTAMP, so
+// bound range parameters compare correctly against stored columns.
+const timestampFormat = "2006-01-02 15:04:05"
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampFormat)
+}
+
+type NutritionTotals struct {
+	Meals  int          `json:"meals"`
+	Totals FoodInsights `json:"totals"`
+}
+
+// intakeQuery selects everything consumed by a user in a time range: meals
+// that are neither spam nor deleted, and beverages. Its parameters are the user ID, start and
+// end, repeated for meals and beverages.
+const intakeQuery = `
+	SELECT TRUE AS is_meal, m.eaten_at AS consumed_at, m.food_insights
+	FROM meals m
+	WHERE m.user_id = ? AND m.is_spam = FALSE AND m.deleted_at IS NULL AND m.eaten_at >= ? AND m.eaten_at < ?
+	UNION ALL
+	SELECT FALSE AS is_meal, b.consumed_at, b.food_insights
+	FROM beverages b
+	WHERE b.user_id = ? AND b.consumed_at >= ? AND b.consumed_at < ?
+`
+
+func intakeArgs(uid int64, startDate, endDate time.Time) []interface{} {
+	start, end := formatTimestamp(startDate), formatTimestamp(endDate)
+	return []interface{}{uid, start, end, uid, start, end}
+}
+
+// GetNutritionTotals sums meals and beverages consumed in [startDate, endDate).
+func (s *storage) GetNutritionTotals(uid int64, startDate, endDate time.Time) (*NutritionTotals, error) {
+	var totals NutritionTotals
+
+	query := `
+		SELECT COALESCE(SUM(i.is_meal), 0),
+			   COALESCE(SUM(json_extract(i.food_insights, '$.calories')), 0),
+			   COALESCE(SUM(json_extract(i.food_insights, '$.proteins')), 0),
+			   COALESCE(SUM(json_extract(i.food_insights, '$.fats')), 0),
+			   COALESCE(SUM(json_extract(i.food_insights, '$.carbohydrates')), 0)
+		FROM (` + intakeQuery + `) i
+	`
+
+	err := s.db.QueryRow(query, intakeArgs(uid, startDate, endDate)...).Scan(
+		&totals.Meals,
+		&totals.Totals.Calories,
+		&totals.Totals.Proteins,
+		&totals.Totals.Fats,
+		&totals.Totals.Carbohydrates,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &totals, nil
+}
+
+type DailyTotals struct {
+	Date   string       `json:"date"`
+	Meals  int          `json:"meals"`
+	Totals FoodInsights `json:"totals"`
+}
+
+// ListDailyTotals sums meals and beverages consumed in [startDate, endDate)
+// per calendar day in loc. Days with nothing logged are omitted.
+func (s *storage) ListDailyTotals(uid int64, startDate, endDate time.Time, loc *time.Location) ([]DailyTotals, error) {
+	query := `
+		SELECT i.is_meal, i.consumed_at, i.food_insights
+		FROM (` + intakeQuery + `) i
+		ORDER BY i.consumed_at
+	`
+
+	rows, err := s.db.Query(query, intakeArgs(uid, startDate, endDate)...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var days []DailyTotals
+
+	for rows.Next() {
+		var isMeal bool
+		var consumedAt time.Time
+		var insights FoodInsights
+
+		if err := rows.Scan(&isMeal, &consumedAt, &insights); err != nil {
+			return nil, err
+		}
+
+		date := consumedAt.In(loc).Format("2006-01-02")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, DailyTotals{Date: date})
+		}
+
+		day := &days[len(days)-1]
+		if isMeal {
+			day.Meals++
+		}
+		day.Totals.Calories += insights.Calories
+		day.Totals.Proteins += insights.Proteins
+		day.Totals.Fats += insights.Fats
+		day.Totals.Carbohydrates += insights.Carbohydrates
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
+
+// MealAnalysis is the result of recognizing a meal photo.
+type MealAnalysis struct {
+	DishName        string
+	IsSpam          bool
+	AestheticRating int
+	HealthRating    int
+	Ingredients     Ingredients
+	FoodInsights    FoodInsights
+
+	// ReplaceEdits replaces ingredients the owner corrected with the new
+	// estimate instead of keeping them.
+	ReplaceEdits bool
+}
+
+// SaveMealAnalysis stores a recognition result. The estimate is also kept in
+// ai_ingredients and ai_food_insights so that manual corrections made later
+// can be compared against it. Ingredients the owner already corrected are
+// kept unless the analysis replaces edits.
+func (s *storage) SaveMealAnalysis(uid, mealID int64, analysis MealAnalysis) (*Meal, error) {
+	q := `
+		UPDATE meals
+		SET dish_name = ?, is_spam = ?, aesthetic_rating = ?, health_rating = ?,
+		    ingredients = CASE WHEN ingredients_edited_at IS NULL OR ? THEN ? ELSE ingredients END, ai_ingredients = ?,
+		    food_insights = CASE WHEN ingredients_edited_at IS NULL OR ? THEN ? ELSE food_insights END, ai_food_insights = ?,
+		    ingredients_edited_at = CASE WHEN ? THEN NULL ELSE ingredients_edited_at END,
+		    hidden_at = NULL, updated_at = CURRENT_TIMESTAMP,
+		    version = version + 1
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+	`
+
+	res, err := s.db.Exec(q,
+		analysis.DishName,
+		analysis.IsSpam,
+		analysis.AestheticRating,
+		analysis.HealthRating,
+		analysis.ReplaceEdits,
+		analysis.Ingredients,
+		analysis.Ingredients,
+		analysis.ReplaceEdits,
+		analysis.FoodInsights,
+		analysis.FoodInsights,
+		analysis.ReplaceEdits,
+		mealID,
+		uid,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.GetMealByID(mealID)
+}
+
+// SetMealIngredients stores manually corrected ingredients with the totals
+// recomputed from them. Like UpdateMeal it only succeeds while the meal is
+// still at the given version and returns ErrConflict otherwise.
+func (s *storage) SetMealIngredients(uid, mealID int64, version int, ingredients Ingredients) (*Meal, error) {
+	q := `
+		UPDATE meals
+		SET ingredients = ?, food_insights = ?, ingredients_edited_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP,
+		    version = version + 1
+		WHERE id = ? AND user_id = ? AND version = ? AND deleted_at IS NULL
+	`
+
+	res, err := s.db.Exec(q, ingredients, ingredients.Totals(), mealID, uid, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		var owner int64
+		err := s.db.QueryRow("SELECT user_id FROM meals WHERE id = ? AND deleted_at IS NULL", mealID).Scan(&owner)
+		if IsNoRowsError(err) || (err == nil && owner != uid) {
+			return nil, ErrNotFound
+		} else if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrConflict
+	}
+
+	return s.GetMealByID(mealID)
+}
+
+// SoftDeleteMeal hides a meal of the user until it is restored or purged.
+// It returns the time of deletion.
+func (s *storage) SoftDeleteMeal(uid, mealID int64) (time.Time, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	q := `
+		UPDATE meals
+		SET deleted_at = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+	`
+
+	res, err := s.db.Exec(q, formatTimestamp(now), mealID, uid)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return time.Time{}, ErrNotFound
+	}
+
+	return now, nil
+}
+
+// RestoreMeal undoes SoftDeleteMeal for a meal deleted after deletedAfter.
+func (s *storage) RestoreMeal(uid, mealID int64, deletedAfter time.Time) (*Meal, error) {
+	q := `
+		UPDATE meals
+		SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = ? AND user_id = ? AND deleted_at > ?
+	`
+
+	res, err := s.db.Exec(q, mealID, uid, formatTimestamp(deletedAfter))
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.GetMealByID(mealID)
+}
+
+// ListDeletedMeals returns meals soft deleted at or before deletedBefore,
+// which are due to be purged. Reactions are not read.
+func (s *storage) ListDeletedMeals(deletedBefore time.Time) ([]Meal, error) {
+	var meals []Meal
+
+	q := `
+		SELECT id, user_id, text, created_at, updated_at, hidden_at, photo_url, thumbnail_url, dish_name, ingredients,
+		       tags, is_spam, food_insights, aesthetic_rating, health_rating, eaten_at, meal_type, ingredients_edited_at,
+		       version, visibility, removed_at, deleted_at
+		FROM meals
+		WHERE deleted_at IS NOT NULL AND deleted_at <= ?
+		ORDER BY deleted_at
+	`
+
+	rows, err := s.db.Query(q, formatTimestamp(deletedBefore))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Meal
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Text, &m.CreatedAt, &m.UpdatedAt, &m.HiddenAt, &m.PhotoURL, &m.ThumbnailURL,
+			&m.DishName, &m.Ingredients, &m.Tags, &m.IsSpam, &m.FoodInsights, &m.AestheticRating, &m.HealthRating,
+			&m.EatenAt, &m.MealType, &m.IngredientsEditedAt, &m.Version, &m.Visibility, &m.RemovedAt, &m.DeletedAt); err != nil {
+			return nil, err
+		}
+
+		meals = append(meals, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return meals, nil
+}
+
+// DeleteMeal permanently removes a soft deleted meal. Its comments and tag
+// links are removed by ON DELETE CASCADE.
+func (s *storage) DeleteMeal(mealID int64) error {
+	res, err := s.db.Exec("DELETE FROM meals WHERE id = ? AND deleted_at IS NOT NULL", mealID)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// StreamUserMeals calls fn for each meal of the user eaten in
+// [startDate, endDate), oldest first, reading rows one at a time so that
+// large ranges are never held in memory. Spam and deleted meals are skipped.
+// Tags holds tag names. Iteration stops at the first error fn returns.
+func (s *storage) StreamUserMeals(uid int64, startDate, endDate time.Time, fn func(Meal) error) error {
+	q := `
+		SELECT m.id, m.user_id, m.text, m.dish_name, m.ingredients, m.food_insights,
+		       m.aesthetic_rating, m.health_rating, m.eaten_at, m.meal_type,
+		       group_concat(t.name, ';') AS tags
+		FROM meals m
+		         LEFT JOIN meal_tags mt ON m.id = mt.meal_id
+		         LEFT JOIN tags t ON mt.tag_id = t.id
+		WHERE m.user_id = ? AND m.eaten_at >= ? AND m.eaten_at < ?
+		  AND m.is_spam = FALSE AND m.deleted_at IS NULL
+		GROUP BY m.id
+		ORDER BY m.eaten_at
+	`
+
+	rows, err := s.db.Query(q, uid, formatTimestamp(startDate), formatTimestamp(endDate))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Meal
+		if err := rows.Scan(
+			&m.ID,
+			&m.UserID,
+			&m.Text,
+			&m.DishName,
+			&m.Ingredients,
+			&m.FoodInsights,
+			&m.AestheticRating,
+			&m.HealthRating,
+			&m.EatenAt,
+			&m.MealType,
+			&m.Tags,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,36 @@
+package db
+
+// ClaimNotification records that a scheduled notification of the given kind
+// is being sent to the user for a local calendar day. It returns false when
+// one was already recorded, which keeps sends idempotent across restarts.
+func (s *storage) ClaimNotification(uid int64, kind, localDate string) (bool, error) {
+	q := `
+		INSERT INTO scheduled_notifications (user_id, kind, local_date)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id, kind, local_date) DO NOTHING
+	`
+
+	res, err := s.db.Exec(q, uid, kind, localDate)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// ReleaseNotification removes a claim so that a failed send is retried.
+func (s *storage) ReleaseNotification(uid int64, kind, localDate string) error {
+	q := `
+		DELETE FROM scheduled_notifications
+		WHERE user_id = ? AND kind = ? AND local_date = ?
+	`
+
+	_, err := s.db.Exec(q, uid, kind, localDate)
+
+	return err
+}
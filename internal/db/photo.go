@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PhotoUpload is a photo that passed validation and was normalized. Only
+// confirmed uploads can be attached to meals, each to a single one, so that
+// purging a meal never removes a photo another meal still shows.
+type PhotoUpload struct {
+	ObjectKey    string    `db:"object_key" json:"file_name"`
+	UserID       int64     `db:"user_id" json:"-"`
+	ThumbnailKey string    `db:"thumbnail_key" json:"thumbnail_file_name"`
+	Width        int       `db:"width" json:"width"`
+	Height       int       `db:"height" json:"height"`
+	MealID       *int64    `db:"meal_id" json:"meal_id"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+func (s *storage) AddPhotoUpload(p PhotoUpload) error {
+	q := `
+		INSERT INTO photo_uploads (object_key, user_id, thumbnail_key, width, height)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (object_key) DO UPDATE SET thumbnail_key = excluded.thumbnail_key, width = excluded.width, height = excluded.height
+	`
+
+	_, err := s.db.Exec(q, p.ObjectKey, p.UserID, p.ThumbnailKey, p.Width, p.Height)
+
+	return err
+}
+
+// GetPhotoUpload returns a confirmed upload of the user.
+func (s *storage) GetPhotoUpload(uid int64, key string) (*PhotoUpload, error) {
+	var p PhotoUpload
+
+	q := `
+		SELECT object_key, user_id, thumbnail_key, width, height, meal_id, created_at
+		FROM photo_uploads
+		WHERE object_key = ? AND user_id = ?
+	`
+
+	err := s.db.QueryRow(q, key, uid).Scan(&p.ObjectKey, &p.UserID, &p.ThumbnailKey, &p.Width, &p.Height, &p.MealID, &p.CreatedAt)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// attachPhotoUpload binds a confirmed upload of the user to a meal. It
+// returns ErrPhotoInUse when the upload belongs to another meal and
+// ErrNotFound when the user has no such upload.
+func attachPhotoUpload(tx *sql.Tx, uid, mealID int64, key string) error {
+	q := `
+		UPDATE photo_uploads
+		SET meal_id = ?
+		WHERE object_key = ? AND user_id = ? AND (meal_id IS NULL OR meal_id = ?)
+	`
+
+	res, err := tx.Exec(q, mealID, key, uid, mealID)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected > 0 {
+		return nil
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM photo_uploads WHERE object_key = ? AND user_id = ?", key, uid).Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrNotFound
+	}
+
+	return ErrPhotoInUse
+}
+
+// PhotoInUse reports whether a meal other than mealID, deleted or not,
+// shows the photo or thumbnail at url. Meals created before uploads were
+// bound to a single meal may share photos.
+func (s *storage) PhotoInUse(mealID int64, url string) (bool, error) {
+	var inUse bool
+
+	q := `
+		SELECT EXISTS (SELECT 1 FROM meals WHERE id != ? AND (photo_url = ? OR thumbnail_url = ?))
+	`
+
+	err := s.db.QueryRow(q, mealID, url, url).Scan(&inUse)
+
+	return inUse, err
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	ReactionHeart = "heart"
+	ReactionFire  = "fire"
+	ReactionYum   = "yum"
+	ReactionClap  = "clap"
+	ReactionWow   = "wow"
+)
+
+// ReactionCounts is the number of reactions on a meal by emoji.
+type ReactionCounts map[string]int
+
+func (rc *ReactionCounts) Scan(src interface{}) error {
+	var source []byte
+	switch src := src.(type) {
+	case []byte:
+		source = src
+	case string:
+		source = []byte(src)
+	case nil:
+		*rc = ReactionCounts{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported type: %T", src)
+	}
+
+	counts := ReactionCounts{}
+	if len(source) > 0 {
+		if err := json.Unmarshal(source, &counts); err != nil {
+			return fmt.Errorf("error unmarshalling ReactionCounts JSON: %w", err)
+		}
+	}
+
+	*rc = counts
+
+	return nil
+}
+
+// reactionColumns select the reaction counts of meals aliased m and the
+// reaction of the viewer, whose ID is the only argument. The counts use the
+// primary key index of meal_reactions instead of a query per meal.
+const reactionColumns = `
+	(SELECT json_group_object(emoji, n)
+	 FROM (SELECT r.emoji, COUNT(*) AS n FROM meal_reactions r WHERE r.meal_id = m.id GROUP BY r.emoji)) AS reactions,
+	(SELECT r.emoji FROM meal_reactions r WHERE r.meal_id = m.id AND r.user_id = ?) AS my_reaction`
+
+// MealReactions are the reactions on a meal as seen by a viewer.
+type MealReactions struct {
+	Reactions  ReactionCounts `json:"reactions"`
+	MyReaction *string        `json:"my_reaction"`
+}
+
+// ToggleMealReaction reacts to a meal viewer may see. Reacting with the
+// emoji the viewer already chose removes it, and another emoji replaces it.
+// It returns ErrNotFound when the meal is missing or hidden from viewer.
+func (s *storage) ToggleMealReaction(viewerID, mealID int64, emoji string) (*MealReactions, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var current *string
+	q := `
+		SELECT (SELECT r.emoji FROM meal_reactions r WHERE r.meal_id = m.id AND r.user_id = ?)
+		FROM meals m
+		WHERE m.id = ? AND m.deleted_at IS NULL AND ` + visibleMeal
+
+	if err := tx.QueryRow(q, viewerID, mealID, viewerID, viewerID).Scan(&current); IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if current != nil && *current == emoji {
+		_, err = tx.Exec("DELETE FROM meal_reactions WHERE meal_id = ? AND user_id = ?", mealID, viewerID)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO meal_reactions (meal_id, user_id, emoji) VALUES (?, ?, ?)
+			ON CONFLICT (meal_id, user_id) DO UPDATE SET emoji = excluded.emoji, created_at = CURRENT_TIMESTAMP
+		`, mealID, viewerID, emoji)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetMealReactions(viewerID, mealID)
+}
+
+// DeleteMealReaction removes the viewer's reaction from a meal they may
+// see. It returns ErrNotFound when the meal is missing or hidden from viewer.
+func (s *storage) DeleteMealReaction(viewerID, mealID int64) (*MealReactions, error) {
+	if _, err := s.db.Exec("DELETE FROM meal_reactions WHERE meal_id = ? AND user_id = ?", mealID, viewerID); err != nil {
+		return nil, err
+	}
+
+	return s.GetMealReactions(viewerID, mealID)
+}
+
+// GetMealReactions returns the reactions on a meal viewer may see. It
+// returns ErrNotFound when the meal is missing or hidden from viewer.
+func (s *storage) GetMealReactions(viewerID, mealID int64) (*MealReactions, error) {
+	var mr MealReactions
+
+	q := `SELECT ` + reactionColumns + `
+		FROM meals m
+		WHERE m.id = ? AND m.deleted_at IS NULL AND ` + visibleMeal
+
+	err := s.db.QueryRow(q, viewerID, mealID, viewerID, viewerID).Scan(&mr.Reactions, &mr.MyReaction)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &mr, nil
+}
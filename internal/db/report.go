@@ -0,0 +1,304 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	ReportReasonSpam          = "spam"
+	ReportReasonAbuse         = "abuse"
+	ReportReasonInappropriate = "inappropriate"
+	ReportReasonOther         = "other"
+
+	// ReportReasonAppeal is used by owners asking for a flagged or removed
+	// meal to be shown again.
+	ReportReasonAppeal = "appeal"
+)
+
+// A report is open until a moderator resolves it with one of the other
+// statuses, which is also the action taken on the reported content.
+const (
+	ReportStatusOpen     = "open"
+	ReportStatusApproved = "approved"
+	ReportStatusRemoved  = "removed"
+	ReportStatusBanned   = "banned"
+)
+
+// Report is a report of a meal or, when CommentID is set, of a comment.
+type Report struct {
+	ID         int64      `db:"id" json:"id"`
+	ReporterID int64      `db:"reporter_id" json:"reporter_id"`
+	MealID     *int64     `db:"meal_id" json:"meal_id"`
+	CommentID  *int64     `db:"comment_id" json:"comment_id"`
+	Reason     string     `db:"reason" json:"reason"`
+	Details    *string    `db:"details" json:"details"`
+	Status     string     `db:"status" json:"status"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	ResolvedAt *time.Time `db:"resolved_at" json:"resolved_at"`
+
+	// ResolvedBy is nil for reports that are open or were resolved
+	// automatically.
+	ResolvedBy *int64 `db:"resolved_by" json:"resolved_by"`
+}
+
+const reportColumns = "id, reporter_id, meal_id, comment_id, reason, details, status, created_at, resolved_at, resolved_by"
+
+func scanReport(row interface{ Scan(...interface{}) error }, r *Report) error {
+	return row.Scan(
+		&r.ID,
+		&r.ReporterID,
+		&r.MealID,
+		&r.CommentID,
+		&r.Reason,
+		&r.Details,
+		&r.Status,
+		&r.CreatedAt,
+		&r.ResolvedAt,
+		&r.ResolvedBy,
+	)
+}
+
+// insertReport runs an INSERT … SELECT … RETURNING of a report. It returns
+// ErrNotFound when nothing is inserted.
+func (s *storage) insertReport(query string, args ...interface{}) (*Report, error) {
+	var r Report
+
+	err := scanReport(s.db.QueryRow(query, args...), &r)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// ReportMeal reports a meal viewer may see. It returns ErrNotFound when the
+// meal is missing or hidden from viewer and ErrAlreadyExists when viewer's
+// earlier report of it is still open.
+func (s *storage) ReportMeal(viewerID, mealID int64, reason string, details *string) (*Report, error) {
+	if _, err := s.GetVisibleMeal(viewerID, mealID); err != nil {
+		return nil, err
+	}
+
+	q := `
+		INSERT INTO reports (reporter_id, meal_id, reason, details)
+		SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM reports WHERE reporter_id = ? AND meal_id = ? AND status = 'open')
+		RETURNING ` + reportColumns
+
+	r, err := s.insertReport(q, viewerID, mealID, reason, details, viewerID, mealID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAlreadyExists
+	}
+
+	return r, err
+}
+
+// ReportComment reports a comment viewer may see. It returns ErrNotFound
+// when the comment is missing or hidden from viewer and ErrAlreadyExists
+// when viewer's earlier report of it is still open.
+func (s *storage) ReportComment(viewerID, commentID int64, reason string, details *string) (*Report, error) {
+	var count int
+
+	q := `
+		SELECT COUNT(*)
+		FROM comments c
+				 JOIN meals m ON m.id = c.meal_id
+		WHERE c.id = ? AND m.deleted_at IS NULL AND ` + visibleMeal + ` AND ` + visibleComment
+
+	if err := s.db.QueryRow(q, commentID, viewerID, viewerID, viewerID).Scan(&count); err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, ErrNotFound
+	}
+
+	q = `
+		INSERT INTO reports (reporter_id, comment_id, reason, details)
+		SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM reports WHERE reporter_id = ? AND comment_id = ? AND status = 'open')
+		RETURNING ` + reportColumns
+
+	r, err := s.insertReport(q, viewerID, commentID, reason, details, viewerID, commentID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAlreadyExists
+	}
+
+	return r, err
+}
+
+// AppealMeal puts a meal of the user that is flagged as spam or removed in
+// the moderation queue. It returns ErrNotFound when the user has no such
+// meal, ErrConflict when the meal is neither flagged nor removed and
+// ErrAlreadyExists when an appeal of it is still open.
+func (s *storage) AppealMeal(uid, mealID int64, details *string) (*Report, error) {
+	meal, err := s.getMeal(uid, "m.id = ? AND m.user_id = ?", mealID, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if !meal.IsSpam && meal.RemovedAt == nil {
+		return nil, ErrConflict
+	}
+
+	q := `
+		INSERT INTO reports (reporter_id, meal_id, reason, details)
+		SELECT ?, ?, 'appeal', ?
+		WHERE NOT EXISTS (SELECT 1 FROM reports WHERE meal_id = ? AND reason = 'appeal' AND status = 'open')
+		RETURNING ` + reportColumns
+
+	r, err := s.insertReport(q, uid, mealID, details, mealID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrAlreadyExists
+	}
+
+	return r, err
+}
+
+// ApproveAppeals resolves the open appeals of a meal that has been cleared
+// without a moderator.
+func (s *storage) ApproveAppeals(mealID int64) error {
+	q := `
+		UPDATE reports
+		SET status = 'approved', resolved_at = CURRENT_TIMESTAMP
+		WHERE meal_id = ? AND reason = 'appeal' AND status = 'open'
+	`
+
+	_, err := s.db.Exec(q, mealID)
+
+	return err
+}
+
+// ListOpenReports returns the moderation queue, oldest first.
+func (s *storage) ListOpenReports(limit int) ([]Report, error) {
+	var reports []Report
+
+	rows, err := s.db.Query("SELECT "+reportColumns+" FROM reports WHERE status = 'open' ORDER BY created_at, id LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Report
+		if err := scanReport(rows, &r); err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func (s *storage) GetReport(id int64) (*Report, error) {
+	var r Report
+
+	err := scanReport(s.db.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ?", id), &r)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// GetComment returns a comment whether or not it was removed.
+func (s *storage) GetComment(id int64) (*Comment, error) {
+	var c Comment
+
+	err := s.db.QueryRow("SELECT id, user_id, meal_id, text, created_at, removed_at FROM comments WHERE id = ?", id).
+		Scan(&c.ID, &c.UserID, &c.MealID, &c.Text, &c.CreatedAt, &c.RemovedAt)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// ResolveReport applies a moderator's decision on an open report to the
+// reported content and resolves every open report of that content with it.
+// Approving shows the content again, also when it was flagged as spam.
+// Removing hides it from everyone but its author, and banning also bans the
+// author. It returns ErrNotFound when there is no open report with the ID.
+func (s *storage) ResolveReport(moderatorID, reportID int64, status string) (*Report, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var r Report
+	err = scanReport(tx.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ? AND status = 'open'", reportID), &r)
+	if IsNoRowsError(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	table, column, targetID := "meals", "meal_id", r.MealID
+	if r.CommentID != nil {
+		table, column, targetID = "comments", "comment_id", r.CommentID
+	}
+
+	var authorID int64
+	if err := tx.QueryRow("SELECT user_id FROM "+table+" WHERE id = ?", *targetID).Scan(&authorID); err != nil {
+		return nil, err
+	}
+
+	var update string
+
+	switch status {
+	case ReportStatusApproved:
+		update = "SET removed_at = NULL"
+		if table == "meals" {
+			update += ", is_spam = FALSE"
+		}
+	case ReportStatusRemoved, ReportStatusBanned:
+		update = "SET removed_at = COALESCE(removed_at, CURRENT_TIMESTAMP)"
+	default:
+		return nil, ErrConflict
+	}
+
+	if _, err := tx.Exec("UPDATE "+table+" "+update+" WHERE id = ?", *targetID); err != nil {
+		return nil, err
+	}
+
+	if status == ReportStatusBanned {
+		if _, err := tx.Exec("UPDATE users SET banned_at = COALESCE(banned_at, CURRENT_TIMESTAMP) WHERE id = ?", authorID); err != nil {
+			return nil, err
+		}
+	}
+
+	q := `
+		UPDATE reports
+		SET status = ?, resolved_at = CURRENT_TIMESTAMP, resolved_by = ?
+		WHERE ` + column + ` = ? AND status = 'open'
+	`
+
+	if _, err := tx.Exec(q, status, moderatorID, *targetID); err != nil {
+		return nil, err
+	}
+
+	if err := scanReport(tx.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ?", reportID), &r); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -5,6 +5,13 @@ import (
 	"time"
 )
 
+// Moderators review reported content. Admins also manage users and tags.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	ID                   int64     `db:"id"`
 	FirstName            *string   `db:"first_name"`
@@ -19,26 +26,54 @@ type User struct {
 	NotificationsEnabled bool      `db:"notifications_enabled"`
 	AvatarURL            *string   `db:"avatar_url"`
 	Title                *string   `db:"title"`
+	Timezone             string    `db:"timezone"`
+	DigestEnabled        bool      `db:"digest_enabled"`
+	DigestTime           string    `db:"digest_time"`
+	WaterTargetML        int       `db:"water_target_ml"`
+
+	// DefaultVisibility is given to new meals logged without one.
+	DefaultVisibility string `db:"default_visibility"`
+
+	// Role is RoleUser, RoleModerator or RoleAdmin.
+	Role string `db:"role"`
+
+	// BannedAt is set when a moderator bans the user. Banned users cannot
+	// use the app and their content is hidden from others.
+	BannedAt *time.Time `db:"banned_at"`
 }
 
-func (s *storage) getUserBy(query string, args ...interface{}) (*User, error) {
-	var user User
-	row := s.db.QueryRowContext(context.Background(), query, args...)
+const userColumns = "id, first_name, last_name, username, language, chat_id, is_premium, created_at, updated_at, last_seen_at, notifications_enabled, avatar_url, title, timezone, digest_enabled, digest_time, water_target_ml, default_visibility, role, banned_at"
 
-	if err := row.Scan(
+func scanUser(row interface{ Scan(...interface{}) error }, user *User) error {
+	return row.Scan(
 		&user.ID,
 		&user.FirstName,
 		&user.LastName,
 		&user.Username,
 		&user.LanguageCode,
 		&user.ChatID,
+		&user.IsPremium,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.LastSeenAt,
 		&user.NotificationsEnabled,
 		&user.AvatarURL,
 		&user.Title,
-	); err != nil && IsNoRowsError(err) {
+		&user.Timezone,
+		&user.DigestEnabled,
+		&user.DigestTime,
+		&user.WaterTargetML,
+		&user.DefaultVisibility,
+		&user.Role,
+		&user.BannedAt,
+	)
+}
+
+func (s *storage) getUserBy(query string, args ...interface{}) (*User, error) {
+	var user User
+	row := s.db.QueryRowContext(context.Background(), query, args...)
+
+	if err := scanUser(row, &user); err != nil && IsNoRowsError(err) {
 		return nil, ErrNotFound
 	} else if err != nil {
 		return nil, err
@@ -48,11 +83,11 @@ func (s *storage) getUserBy(query string, args ...interface{}) (*User, error) {
 }
 
 func (s *storage) GetUserByID(id int64) (*User, error) {
-	return s.getUserBy("SELECT id, first_name, last_name, username, language, chat_id, created_at, updated_at, last_seen_at, notifications_enabled, avatar_url, title FROM users WHERE id = ?", id)
+	return s.getUserBy("SELECT "+userColumns+" FROM users WHERE id = ?", id)
 }
 
 func (s *storage) GetUserByChatID(chatID int64) (*User, error) {
-	return s.getUserBy("SELECT id, first_name, last_name, username, language, chat_id, created_at, updated_at, last_seen_at, notifications_enabled, avatar_url, title FROM users WHERE chat_id = ?", chatID)
+	return s.getUserBy("SELECT "+userColumns+" FROM users WHERE chat_id = ?", chatID)
 }
 
 func (s *storage) UpdateUserAvatarURL(uid int64, url string) error {
@@ -75,29 +110,12 @@ func (s *storage) UpdateUserAvatarURL(uid int64, url string) error {
 	return err
 }
 
-func (s *storage) DeleteUserByID(uid int64) error {
-	q := `
-		DELETE FROM users
-		WHERE id = ?
-	`
-
-	res, err := s.db.Exec(q, uid)
-
-	if err != nil {
-		return err
-	}
-
-	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
-		return ErrNotFound
-	}
-
-	return err
-}
-
 func (s *storage) UpdateUser(uid int64, user User) (*User, error) {
 	q := `
 		UPDATE users
-		SET first_name = ?, last_name = ?, username = ?, language = ?, is_premium = ?, notifications_enabled = ?
+		SET first_name = ?, last_name = ?, username = ?, language = ?, is_premium = ?, notifications_enabled = ?,
+		    timezone = ?, digest_enabled = ?, digest_time = ?, water_target_ml = ?, default_visibility = ?,
+		    updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
 
@@ -108,6 +126,11 @@ func (s *storage) UpdateUser(uid int64, user User) (*User, error) {
 		user.LanguageCode,
 		user.IsPremium,
 		user.NotificationsEnabled,
+		user.Timezone,
+		user.DigestEnabled,
+		user.DigestTime,
+		user.WaterTargetML,
+		user.DefaultVisibility,
 		uid,
 	)
 
@@ -143,3 +166,31 @@ func (s *storage) CreateUser(user User) error {
 
 	return nil
 }
+
+// ListNotifiableUsers returns users that have not turned notifications off
+// and are not banned.
+func (s *storage) ListNotifiableUsers() ([]User, error) {
+	var users []User
+
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users WHERE notifications_enabled = TRUE AND banned_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
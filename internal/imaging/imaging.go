@@ -0,0 +1,158 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"github.com/gen2brain/heic"
+	"golang.org/x/image/webp"
+)
+
+const (
+	MinSide   = 64
+	MaxSide   = 10000
+	MaxPixels = 50_000_000
+
+	jpegQuality = 85
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrBadDimensions     = errors.New("unsupported image dimensions")
+)
+
+// Decode checks that data is a JPEG, PNG, GIF, WebP or HEIC by its magic bytes and that
+// its dimensions are within limits before decoding it. JPEG images are
+// rotated upright according to their EXIF orientation, since metadata is not
+// kept when the image is encoded again.
+func Decode(data []byte) (*image.RGBA, error) {
+	contentType := http.DetectContentType(data)
+	if isHEIC(data) {
+		contentType = "image/heic"
+	}
+
+	var decode func([]byte) (image.Image, error)
+	var decodeConfig func([]byte) (image.Config, error)
+
+	switch contentType {
+	case "image/jpeg":
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) }
+	case "image/png":
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) }
+	case "image/gif":
+		decode = func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) }
+	case "image/webp":
+		decode = func(b []byte) (image.Image, error) { return webp.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return webp.DecodeConfig(bytes.NewReader(b)) }
+	case "image/heic":
+		decode = func(b []byte) (image.Image, error) { return heic.Decode(bytes.NewReader(b)) }
+		decodeConfig = func(b []byte) (image.Config, error) { return heic.DecodeConfig(bytes.NewReader(b)) }
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+
+	cfg, err := decodeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+
+	if cfg.Width < MinSide || cfg.Height < MinSide || cfg.Width > MaxSide || cfg.Height > MaxSide || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrBadDimensions, cfg.Width, cfg.Height)
+	}
+
+	img, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+
+	// Transparent areas become white since JPEG has no alpha channel.
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	if contentType == "image/jpeg" {
+		rgba = orient(rgba, exifOrientation(data))
+	}
+
+	return rgba, nil
+}
+
+// isHEIC reports whether data starts with the ftyp box of a HEIF file, which
+// http.DetectContentType does not recognize. The decoder applies the
+// rotation stored in the file itself.
+func isHEIC(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+
+	switch string(data[8:12]) {
+	case "heic", "heix", "heim", "heis", "mif1":
+		return true
+	default:
+		return false
+	}
+}
+
+// Fit scales img down so that neither side exceeds maxSide, averaging the
+// source pixels covered by each destination pixel. Smaller images are
+// returned unchanged.
+func Fit(img *image.RGBA, maxSide int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= maxSide && h <= maxSide {
+		return img
+	}
+
+	dw, dh := maxSide, h*maxSide/w
+	if h > w {
+		dw, dh = w*maxSide/h, maxSide
+	}
+
+	dw, dh = max(dw, 1), max(dh, 1)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*h/dh, max((dy+1)*h/dh, dy*h/dh+1)
+
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*w/dw, max((dx+1)*w/dw, dx*w/dw+1)
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := img.Pix[y*img.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[dy*dst.Stride+dx*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+
+	return dst
+}
+
+// EncodeJPEG encodes img without any metadata.
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
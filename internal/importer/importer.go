@@ -0,0 +1,327 @@
+package importer
+
+import (
+	"bytes"
+	"eatsome/internal/db"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SourceMyFitnessPal = "myfitnesspal"
+	SourceCronometer   = "cronometer"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Meal is a meal read from another tracker's export. Key identifies it
+// within the source so that importing the same export twice is a no-op.
+type Meal struct {
+	Key          string
+	Date         string
+	Time         *time.Duration
+	MealType     string
+	Ingredients  db.Ingredients
+	FoodInsights db.FoodInsights
+	Note         string
+}
+
+// EatenAt returns when the meal was eaten in loc. Without a time of day a
+// typical hour for the meal type is used.
+func (m Meal) EatenAt(loc *time.Location) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", m.Date, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if m.Time != nil {
+		return day.Add(*m.Time), nil
+	}
+
+	return day.Add(time.Duration(mealTypeHours[m.MealType]) * time.Hour), nil
+}
+
+var mealTypeHours = map[string]int{
+	db.MealTypeBreakfast: 8,
+	db.MealTypeLunch:     13,
+	db.MealTypeSnack:     16,
+	db.MealTypeDinner:    19,
+}
+
+// Detect returns the source of a CSV export from its header.
+func Detect(data []byte) (string, error) {
+	header, err := csv.NewReader(bytes.NewReader(data)).Read()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+	}
+
+	columns := columnIndex(header)
+
+	switch {
+	case hasColumns(columns, "Food Name", "Energy (kcal)", "Group"):
+		return SourceCronometer, nil
+	case hasColumns(columns, "Date", "Meal", "Calories", "Protein (g)"):
+		return SourceMyFitnessPal, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// Parse reads a CSV export of the given source into meals, one per day and
+// meal type, in the order they appear.
+func Parse(source string, data []byte) ([]Meal, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	columns := columnIndex(header)
+
+	var parseRow func(row []string) (Meal, error)
+	switch source {
+	case SourceMyFitnessPal:
+		parseRow = func(row []string) (Meal, error) { return parseMyFitnessPal(columns, row) }
+	case SourceCronometer:
+		parseRow = func(row []string) (Meal, error) { return parseCronometer(columns, row) }
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	var meals []Meal
+	byKey := map[string]int{}
+
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		m, err := parseRow(row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		// Rows of the same meal are merged into one. Rows with foods are
+		// totalled below; rows with only totals, such as those of custom
+		// MyFitnessPal meals that all map to snacks, are added up here.
+		if i, ok := byKey[m.Key]; ok {
+			merged := &meals[i]
+			merged.Ingredients = append(merged.Ingredients, m.Ingredients...)
+			if len(m.Ingredients) == 0 {
+				merged.FoodInsights.Calories += m.FoodInsights.Calories
+				merged.FoodInsights.Proteins += m.FoodInsights.Proteins
+				merged.FoodInsights.Fats += m.FoodInsights.Fats
+				merged.FoodInsights.Carbohydrates += m.FoodInsights.Carbohydrates
+			}
+			if merged.Time == nil {
+				merged.Time = m.Time
+			}
+			if m.Note != "" {
+				merged.Note = strings.TrimSpace(merged.Note + "\n" + m.Note)
+			}
+			continue
+		}
+
+		byKey[m.Key] = len(meals)
+		meals = append(meals, m)
+	}
+
+	for i := range meals {
+		if len(meals[i].Ingredients) > 0 {
+			meals[i].FoodInsights = meals[i].Ingredients.Totals()
+		}
+	}
+
+	return meals, nil
+}
+
+// parseMyFitnessPal reads a row of the MyFitnessPal nutrition export, which
+// has totals per meal but no foods.
+func parseMyFitnessPal(columns map[string]int, row []string) (Meal, error) {
+	date, err := parseDate(field(columns, row, "Date"))
+	if err != nil {
+		return Meal{}, err
+	}
+
+	mealType := mapMealType(field(columns, row, "Meal"))
+
+	calories, err := parseNumber(field(columns, row, "Calories"))
+	if err != nil {
+		return Meal{}, err
+	}
+
+	proteins, err := parseNumber(field(columns, row, "Protein (g)"))
+	if err != nil {
+		return Meal{}, err
+	}
+
+	fats, err := parseNumber(field(columns, row, "Fat (g)"))
+	if err != nil {
+		return Meal{}, err
+	}
+
+	carbohydrates, err := parseNumber(field(columns, row, "Carbohydrates (g)"))
+	if err != nil {
+		return Meal{}, err
+	}
+
+	return Meal{
+		Key:      fmt.Sprintf("%s:%s:%s", SourceMyFitnessPal, date, mealType),
+		Date:     date,
+		MealType: mealType,
+		FoodInsights: db.FoodInsights{
+			Calories:      int(math.Round(calories)),
+			Proteins:      int(math.Round(proteins)),
+			Fats:          int(math.Round(fats)),
+			Carbohydrates: int(math.Round(carbohydrates)),
+		},
+		Note: field(columns, row, "Note"),
+	}, nil
+}
+
+// parseCronometer reads a row of the Cronometer servings export, which has
+// one food per row.
+func parseCronometer(columns map[string]int, row []string) (Meal, error) {
+	date, err := parseDate(field(columns, row, "Day"))
+	if err != nil {
+		return Meal{}, err
+	}
+
+	mealType := mapMealType(field(columns, row, "Group"))
+
+	var ingredient db.Ingredient
+	ingredient.Name = field(columns, row, "Food Name")
+	ingredient.Weight = parseGrams(field(columns, row, "Amount"))
+
+	numbers := []struct {
+		column string
+		value  *float64
+	}{
+		{"Energy (kcal)", &ingredient.Calories},
+		{"Protein (g)", &ingredient.Macros.Proteins},
+		{"Fat (g)", &ingredient.Macros.Fats},
+		{"Carbs (g)", &ingredient.Macros.Carbohydrates},
+	}
+
+	for _, n := range numbers {
+		if *n.value, err = parseNumber(field(columns, row, n.column)); err != nil {
+			return Meal{}, err
+		}
+	}
+
+	m := Meal{
+		Key:         fmt.Sprintf("%s:%s:%s", SourceCronometer, date, mealType),
+		Date:        date,
+		MealType:    mealType,
+		Ingredients: db.Ingredients{ingredient},
+	}
+
+	if t, ok := parseTimeOfDay(field(columns, row, "Time")); ok {
+		m.Time = &t
+	}
+
+	return m, nil
+}
+
+func columnIndex(header []string) map[string]int {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+
+	return columns
+}
+
+func hasColumns(columns map[string]int, names ...string) bool {
+	for _, name := range names {
+		if _, ok := columns[name]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func field(columns map[string]int, row []string, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+
+	return strings.TrimSpace(row[i])
+}
+
+func parseDate(s string) (string, error) {
+	for _, layout := range []string{"2006-01-02", "01/02/2006", "1/2/2006"} {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d.Format("2006-01-02"), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid date %q", s)
+}
+
+func parseTimeOfDay(s string) (time.Duration, bool) {
+	for _, layout := range []string{"15:04", "15:04:05", "3:04 PM", "3:04PM"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+		}
+	}
+
+	return 0, false
+}
+
+// parseNumber reads a nutrient value. Empty cells count as zero.
+func parseNumber(s string) (float64, error) {
+	s = strings.ReplaceAll(s, ",", "")
+	if s == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return f, nil
+}
+
+// parseGrams reads an amount such as "150.00 g". Amounts in other units
+// have no known weight and return zero.
+func parseGrams(s string) float64 {
+	value, unit, _ := strings.Cut(s, " ")
+	if unit != "g" {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+
+	return f
+}
+
+func mapMealType(group string) string {
+	switch strings.ToLower(group) {
+	case "breakfast":
+		return db.MealTypeBreakfast
+	case "lunch":
+		return db.MealTypeLunch
+	case "dinner":
+		return db.MealTypeDinner
+	default:
+		return db.MealTypeSnack
+	}
+}
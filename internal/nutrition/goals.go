@@ -0,0 +1,99 @@
+package nutrition
+
+import (
+	"fmt"
+	"math"
+)
+
+type Sex string
+
+const (
+	SexMale   Sex = "male"
+	SexFemale Sex = "female"
+)
+
+type ActivityLevel string
+
+const (
+	ActivitySedentary  ActivityLevel = "sedentary"
+	ActivityLight      ActivityLevel = "light"
+	ActivityModerate   ActivityLevel = "moderate"
+	ActivityActive     ActivityLevel = "active"
+	ActivityVeryActive ActivityLevel = "very_active"
+)
+
+var activityFactors = map[ActivityLevel]float64{
+	ActivitySedentary:  1.2,
+	ActivityLight:      1.375,
+	ActivityModerate:   1.55,
+	ActivityActive:     1.725,
+	ActivityVeryActive: 1.9,
+}
+
+const (
+	// KcalPerKgBodyWeight is the usual energy equivalent of one kilogram of body weight change.
+	KcalPerKgBodyWeight = 7700
+
+	proteinPerKg = 1.6
+	fatShare     = 0.25
+
+	KcalPerGramProtein      = 4
+	KcalPerGramFat          = 9
+	KcalPerGramCarbohydrate = 4
+)
+
+type Profile struct {
+	Age           int
+	Sex           Sex
+	HeightCm      float64
+	WeightKg      float64
+	ActivityLevel ActivityLevel
+}
+
+type Targets struct {
+	Calories      int
+	Proteins      int
+	Fats          int
+	Carbohydrates int
+}
+
+// BMR returns the basal metabolic rate in kcal per day using the
+// Mifflin-St Jeor equation.
+func BMR(p Profile) float64 {
+	bmr := 10*p.WeightKg + 6.25*p.HeightCm - 5*float64(p.Age)
+	if p.Sex == SexMale {
+		return bmr + 5
+	}
+
+	return bmr - 161
+}
+
+// TDEE returns the total daily energy expenditure for the profile's activity level.
+func TDEE(p Profile) (float64, error) {
+	factor, ok := activityFactors[p.ActivityLevel]
+	if !ok {
+		return 0, fmt.Errorf("unknown activity level: %s", p.ActivityLevel)
+	}
+
+	return BMR(p) * factor, nil
+}
+
+// CalculateTargets derives maintenance calories and a macro split from the
+// profile: protein by body weight, a fixed share of fat and the rest as carbohydrates.
+func CalculateTargets(p Profile) (Targets, error) {
+	tdee, err := TDEE(p)
+	if err != nil {
+		return Targets{}, err
+	}
+
+	proteins := proteinPerKg * p.WeightKg
+	fats := tdee * fatShare / KcalPerGramFat
+	carbohydrates := math.Max(0, (tdee-proteins*KcalPerGramProtein-fats*KcalPerGramFat)/KcalPerGramCarbohydrate)
+
+	return Targets{
+		Calories:      int(math.Round(tdee)),
+		Proteins:      int(math.Round(proteins)),
+		Fats:          int(math.Round(fats)),
+		Carbohydrates: int(math.Round(carbohydrates)),
+	}, nil
+}
@@ -0,0 +1,82 @@
+package nutrition
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	kgPerLb = 0.45359237
+	cmPerIn = 2.54
+)
+
+var ErrNotEnoughData = errors.New("not enough data")
+
+func PoundsToKg(lb float64) float64 {
+	return lb * kgPerLb
+}
+
+func InchesToCm(in float64) float64 {
+	return in * cmPerIn
+}
+
+// Point is a measurement at a moment in time.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// MovingAverage returns, for every point, the mean of the points measured
+// within window up to and including it. Points must be sorted by time.
+func MovingAverage(points []Point, window time.Duration) []float64 {
+	avgs := make([]float64, len(points))
+
+	start := 0
+	var sum float64
+	for i, p := range points {
+		sum += p.Value
+
+		from := p.Time.Add(-window)
+		for !points[start].Time.After(from) {
+			sum -= points[start].Value
+			start++
+		}
+
+		avgs[i] = sum / float64(i-start+1)
+	}
+
+	return avgs
+}
+
+// Slope returns the least-squares slope of the points in units per day.
+func Slope(points []Point) (float64, error) {
+	if len(points) < 2 {
+		return 0, ErrNotEnoughData
+	}
+
+	origin := points[0].Time
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		x := p.Time.Sub(origin).Hours() / 24
+		sumX += x
+		sumY += p.Value
+		sumXY += x * p.Value
+		sumXX += x * x
+	}
+
+	n := float64(len(points))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, ErrNotEnoughData
+	}
+
+	return (n*sumXY - sumX*sumY) / denominator, nil
+}
+
+// EstimateTDEE estimates actual energy expenditure from the average daily
+// intake and the weight trend over the same period: every kilogram lost
+// accounts for KcalPerKgBodyWeight burned above intake.
+func EstimateTDEE(avgIntake float64, weightSlopeKgPerDay float64) float64 {
+	return avgIntake - weightSlopeKgPerDay*KcalPerKgBodyWeight
+}
@@ -3,15 +3,21 @@ package s3
 import (
 	"bytes"
 	"context"
+	"eatsome/internal/blob"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
-	"strings"
+	"io"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+var _ blob.BlobStore = (*Client)(nil)
+
 type Client struct {
 	S3Client *s3.Client
 	Bucket   string
@@ -43,38 +49,34 @@ func NewS3Client(accessKeyId, accessKeySecret, endpoint, bucket string) (*Client
 	}, nil
 }
 
-func (s *Client) GetPresignedURL(objectKey string, duration time.Duration) (string, error) {
+// GetPresignedURL returns a PUT request that uploads exactly size bytes of
+// the content type matching the object key's extension.
+func (s *Client) GetPresignedURL(objectKey string, size int64, duration time.Duration) (*blob.PresignedUpload, error) {
 	signer := s3.NewPresignClient(s.S3Client)
 
 	request, err := signer.PresignPutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(objectKey),
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(objectKey),
+		ContentType:   aws.String(blob.ContentType(objectKey)),
+		ContentLength: aws.Int64(size),
 	}, func(opts *s3.PresignOptions) {
 		opts.Expires = duration
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return request.URL, err
-}
-
-func resolveContentType(fileName string) string {
-	switch {
-	case strings.HasSuffix(fileName, ".jpg"):
-		return "image/jpeg"
-	case strings.HasSuffix(fileName, ".jpeg"):
-		return "image/jpeg"
-	case strings.HasSuffix(fileName, ".png"):
-		return "image/png"
-	case strings.HasSuffix(fileName, ".gif"):
-		return "image/gif"
-	case strings.HasSuffix(fileName, ".webp"):
-		return "image/webp"
-	default:
-		return "application/octet-stream"
+	headers := make(map[string]string, len(request.SignedHeader))
+	for name := range request.SignedHeader {
+		// Browsers set these themselves and refuse to send them explicitly.
+		if name == "Host" || name == "Content-Length" {
+			continue
+		}
+		headers[name] = request.SignedHeader.Get(name)
 	}
+
+	return &blob.PresignedUpload{URL: request.URL, Headers: headers}, nil
 }
 
 func (s *Client) UploadFile(file []byte, fileName string) (string, error) {
@@ -82,12 +84,207 @@ func (s *Client) UploadFile(file []byte, fileName string) (string, error) {
 		Bucket:      aws.String(s.Bucket),
 		Key:         aws.String(fileName),
 		Body:        bytes.NewReader(file),
-		ContentType: aws.String(resolveContentType(fileName)),
+		ContentType: aws.String(blob.ContentType(fileName)),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return s.GetPresignedDownloadURL(fileName, time.Hour)
+}
+
+// multipartPartSize is the size of the parts of streamed uploads, the
+// smallest S3 accepts for all parts but the last.
+const multipartPartSize = 5 << 20
+
+// UploadStream stores an object read from r as a multipart upload, so that
+// only one part is held in memory at a time.
+func (s *Client) UploadStream(ctx context.Context, r io.Reader, fileName string) error {
+	created, err := s.S3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(fileName),
+		ContentType: aws.String(blob.ContentType(fileName)),
+	})
+	if err != nil {
+		return err
+	}
+
+	parts, err := s.uploadParts(ctx, r, fileName, created.UploadId)
+	if err == nil {
+		_, err = s.S3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.Bucket),
+			Key:             aws.String(fileName),
+			UploadId:        created.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+
+	if err != nil {
+		// Parts of an upload that is neither completed nor aborted are
+		// kept, and billed, by the bucket.
+		_, abortErr := s.S3Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.Bucket),
+			Key:      aws.String(fileName),
+			UploadId: created.UploadId,
+		})
+
+		return errors.Join(err, abortErr)
+	}
+
+	return nil
+}
+
+func (s *Client) uploadParts(ctx context.Context, r io.Reader, fileName string, uploadID *string) ([]types.CompletedPart, error) {
+	var parts []types.CompletedPart
+
+	buf := make([]byte, multipartPartSize)
+
+	for number := int32(1); ; number++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr == io.EOF && len(parts) > 0 {
+			return parts, nil
+		} else if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return nil, readErr
+		}
+
+		out, err := s.S3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(s.Bucket),
+			Key:        aws.String(fileName),
+			UploadId:   uploadID,
+			PartNumber: aws.Int32(number),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+
+		// A short read is the last part.
+		if readErr != nil {
+			return parts, nil
+		}
+	}
+}
+
+// DeleteFile removes an object from the bucket. Deleting a missing object
+// is not an error.
+func (s *Client) DeleteFile(ctx context.Context, fileName string) error {
+	_, err := s.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(fileName),
+	})
+
+	return err
+}
+
+// DeletePrefix removes every object whose key starts with prefix.
+func (s *Client) DeletePrefix(ctx context.Context, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(s.S3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+		}
+
+		out, err := s.S3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("failed to delete %s: %s", aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
+		}
+	}
+
+	return nil
+}
+
+// GetFile downloads an object.
+func (s *Client) GetFile(ctx context.Context, fileName string) ([]byte, error) {
+	out, err := s.S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(fileName),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("%s: %w", fileName, blob.ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+// GetPresignedDownloadURL returns a URL that allows downloading an object
+// for the given duration.
+func (s *Client) GetPresignedDownloadURL(objectKey string, duration time.Duration) (string, error) {
+	signer := s3.NewPresignClient(s.S3Client)
+
+	request, err := signer.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(objectKey),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = duration
 	})
 
 	if err != nil {
 		return "", err
 	}
 
-	return s.GetPresignedURL(fileName, time.Hour)
+	return request.URL, nil
+}
+
+// HeadFile returns the size of an object in bytes.
+func (s *Client) HeadFile(ctx context.Context, fileName string) (int64, error) {
+	out, err := s.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+// ListFiles returns the keys of objects that start with prefix.
+func (s *Client) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.S3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var keys []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+
+	return keys, nil
 }
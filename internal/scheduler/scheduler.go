@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// Scheduler runs periodic jobs inside the API process. Jobs must be
+// idempotent: a tick can be repeated after a restart.
+type Scheduler struct {
+	jobs []job
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+func (s *Scheduler) Add(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: run})
+}
+
+// Start launches every job in its own goroutine. Jobs stop when ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, j := range s.jobs {
+		go s.loop(ctx, j)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, j job) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx, j)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Scheduled job %s panicked: %v", j.name, r)
+		}
+	}()
+
+	if err := j.run(ctx); err != nil {
+		log.Printf("Scheduled job %s failed: %v", j.name, err)
+	}
+}
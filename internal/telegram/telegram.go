@@ -0,0 +1,248 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBaseURL = "https://api.telegram.org"
+
+	// Bot API limits: about 30 messages per second overall and one message
+	// per second to the same chat.
+	globalRateLimit  = 30
+	perChatRateLimit = 1
+
+	maxSendAttempts = 3
+	maxRetryAfter   = time.Minute
+)
+
+type Client struct {
+	Token   string
+	BaseURL string
+
+	httpClient *http.Client
+
+	limiter      *rate.Limiter
+	chatLimiters sync.Map
+}
+
+// New creates a Bot API client. An empty baseURL selects the public Telegram API.
+func New(token, baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	return &Client{
+		Token:      token,
+		BaseURL:    baseURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		limiter:    rate.NewLimiter(globalRateLimit, globalRateLimit),
+	}
+}
+
+type User struct {
+	ID           int64  `json:"id"`
+	IsBot        bool   `json:"is_bot"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+type Chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type PhotoSize struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	FileSize     int64  `json:"file_size"`
+}
+
+type Message struct {
+	MessageID int64       `json:"message_id"`
+	From      *User       `json:"from"`
+	Chat      Chat        `json:"chat"`
+	Date      int64       `json:"date"`
+	Text      string      `json:"text"`
+	Caption   string      `json:"caption"`
+	Photo     []PhotoSize `json:"photo"`
+}
+
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+type File struct {
+	FileID   string `json:"file_id"`
+	FileSize int64  `json:"file_size"`
+	FilePath string `json:"file_path"`
+}
+
+type InlineKeyboardButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url,omitempty"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type SendMessageRequest struct {
+	ChatID           int64                 `json:"chat_id"`
+	Text             string                `json:"text"`
+	ParseMode        string                `json:"parse_mode,omitempty"`
+	ReplyToMessageID int64                 `json:"reply_to_message_id,omitempty"`
+	ReplyMarkup      *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// Error is returned when the Bot API answers with ok=false.
+type Error struct {
+	Code        int
+	Description string
+	RetryAfter  time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("telegram: %d %s", e.Code, e.Description)
+}
+
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  *struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+func (c *Client) call(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s params: %w", method, err)
+	}
+
+	endpoint := fmt.Sprintf("%s/bot%s/%s", c.BaseURL, c.Token, method)
+
+	resp, err := c.httpClient.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send %s request: %w", method, redactURL(err))
+	}
+
+	defer resp.Body.Close()
+
+	var apiResp apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+
+	if !apiResp.OK {
+		tgErr := &Error{Code: apiResp.ErrorCode, Description: apiResp.Description}
+		if apiResp.Parameters != nil {
+			tgErr.RetryAfter = time.Duration(apiResp.Parameters.RetryAfter) * time.Second
+		}
+
+		return tgErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(apiResp.Result, result); err != nil {
+		return fmt.Errorf("failed to unmarshal %s result: %w", method, err)
+	}
+
+	return nil
+}
+
+// redactURL strips the request URL, which contains the bot token, from
+// transport errors so that they can be logged.
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+
+	return err
+}
+
+func (c *Client) chatLimiter(chatID int64) *rate.Limiter {
+	limiter, _ := c.chatLimiters.LoadOrStore(chatID, rate.NewLimiter(perChatRateLimit, 1))
+	return limiter.(*rate.Limiter)
+}
+
+// SendMessage delivers a message within the Bot API rate limits. When
+// Telegram answers 429 the call waits for the advertised retry_after and
+// tries again.
+func (c *Client) SendMessage(req SendMessageRequest) error {
+	ctx := context.Background()
+
+	var err error
+	for attempt := 0; attempt < maxSendAttempts; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		if err := c.chatLimiter(req.ChatID).Wait(ctx); err != nil {
+			return err
+		}
+
+		err = c.call("sendMessage", req, nil)
+
+		var tgErr *Error
+		if !errors.As(err, &tgErr) || tgErr.Code != http.StatusTooManyRequests {
+			return err
+		}
+
+		if tgErr.RetryAfter > maxRetryAfter {
+			return err
+		}
+
+		time.Sleep(tgErr.RetryAfter)
+	}
+
+	return err
+}
+
+func (c *Client) GetFile(fileID string) (*File, error) {
+	var file File
+	if err := c.call("getFile", map[string]string{"file_id": fileID}, &file); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// DownloadFile fetches the contents of a file previously resolved with GetFile.
+func (c *Client) DownloadFile(filePath string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/file/bot%s/%s", c.BaseURL, c.Token, filePath)
+
+	resp, err := c.httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", redactURL(err))
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
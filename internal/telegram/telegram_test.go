@@ -0,0 +1,195 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// botAPIStub answers sendMessage calls with the responses in order and
+// records the requests it received.
+type botAPIStub struct {
+	mu        sync.Mutex
+	responses []string
+	requests  []SendMessageRequest
+}
+
+func (s *botAPIStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/sendMessage") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+
+	resp := `{"ok":true,"result":{}}`
+	if len(s.responses) > 0 {
+		resp, s.responses = s.responses[0], s.responses[1:]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, resp)
+}
+
+func (s *botAPIStub) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.requests)
+}
+
+func newStubClient(t *testing.T, stub *botAPIStub) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	return New("123:secret", srv.URL)
+}
+
+func tooManyRequests(retryAfter int) string {
+	return fmt.Sprintf(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after %d","parameters":{"retry_after":%d}}`, retryAfter, retryAfter)
+}
+
+func TestSendMessage(t *testing.T) {
+	stub := &botAPIStub{}
+	client := newStubClient(t, stub)
+
+	if err := client.SendMessage(SendMessageRequest{ChatID: 42, Text: "hello"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if stub.calls() != 1 {
+		t.Fatalf("calls = %d, want 1", stub.calls())
+	}
+
+	if got := stub.requests[0]; got.ChatID != 42 || got.Text != "hello" {
+		t.Errorf("request = %+v", got)
+	}
+}
+
+func TestSendMessageRetriesAfter429(t *testing.T) {
+	stub := &botAPIStub{responses: []string{tooManyRequests(1)}}
+	client := newStubClient(t, stub)
+
+	start := time.Now()
+
+	if err := client.SendMessage(SendMessageRequest{ChatID: 42, Text: "hello"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if stub.calls() != 2 {
+		t.Fatalf("calls = %d, want 2", stub.calls())
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least retry_after", elapsed)
+	}
+}
+
+func TestSendMessageGivesUpOnLongRetryAfter(t *testing.T) {
+	retryAfter := int((maxRetryAfter + time.Second) / time.Second)
+
+	stub := &botAPIStub{responses: []string{tooManyRequests(retryAfter)}}
+	client := newStubClient(t, stub)
+
+	err := client.SendMessage(SendMessageRequest{ChatID: 42, Text: "hello"})
+
+	var tgErr *Error
+	if !errors.As(err, &tgErr) || tgErr.Code != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want a 429 error", err)
+	}
+
+	if tgErr.RetryAfter != time.Duration(retryAfter)*time.Second {
+		t.Errorf("RetryAfter = %v", tgErr.RetryAfter)
+	}
+
+	if stub.calls() != 1 {
+		t.Errorf("calls = %d, want 1", stub.calls())
+	}
+}
+
+func TestSendMessageGivesUpAfterMaxAttempts(t *testing.T) {
+	stub := &botAPIStub{responses: []string{tooManyRequests(0), tooManyRequests(0), tooManyRequests(0), tooManyRequests(0)}}
+	client := newStubClient(t, stub)
+
+	err := client.SendMessage(SendMessageRequest{ChatID: 42, Text: "hello"})
+
+	var tgErr *Error
+	if !errors.As(err, &tgErr) || tgErr.Code != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want a 429 error", err)
+	}
+
+	if stub.calls() != maxSendAttempts {
+		t.Errorf("calls = %d, want %d", stub.calls(), maxSendAttempts)
+	}
+}
+
+func TestSendMessageLimitsEachChat(t *testing.T) {
+	stub := &botAPIStub{}
+	client := newStubClient(t, stub)
+
+	start := time.Now()
+
+	for _, chatID := range []int64{1, 2, 3} {
+		if err := client.SendMessage(SendMessageRequest{ChatID: chatID, Text: "hello"}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("messages to different chats took %v", elapsed)
+	}
+
+	start = time.Now()
+
+	for i := 0; i < 2; i++ {
+		if err := client.SendMessage(SendMessageRequest{ChatID: 4, Text: "hello"}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("two messages to one chat took %v, want about a second", elapsed)
+	}
+}
+
+func TestErrorsDoNotLeakToken(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	client := New("123:secret", srv.URL)
+
+	err := client.SendMessage(SendMessageRequest{ChatID: 42, Text: "hello"})
+	if err == nil {
+		t.Fatal("SendMessage to a closed server succeeded")
+	}
+
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error contains the token: %v", err)
+	}
+
+	_, err = client.DownloadFile("photos/file.jpg")
+	if err == nil {
+		t.Fatal("DownloadFile from a closed server succeeded")
+	}
+
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error contains the token: %v", err)
+	}
+}
@@ -54,3 +54,11 @@ func Unauthorized(err error, message string) *Error {
 		Message: message,
 	}
 }
+
+func Forbidden(err error, message string) *Error {
+	return &Error{
+		Code:    http.StatusForbidden,
+		Err:     err,
+		Message: message,
+	}
+}
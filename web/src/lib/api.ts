@@ -12,7 +12,7 @@ export const apiFetch = async ({
 	responseContentType = 'json' as 'json' | 'blob',
 }: {
 	endpoint: string
-	method?: 'GET' | 'POST' | 'PUT' | 'DELETE'
+	method?: 'GET' | 'POST' | 'PUT' | 'PATCH' | 'DELETE'
 	body?: unknown
 	showProgress?: boolean
 	responseContentType?: string
@@ -93,7 +93,7 @@ export async function fetchCreatePost(post: any) {
 export async function fetchUpdatePost(id: number, post: any) {
 	const response = await apiFetch({
 		endpoint: `/meals/${id}`,
-		method: 'PUT',
+		method: 'PATCH',
 		body: post,
 	})
 
@@ -108,14 +108,16 @@ export async function fetchPostAISuggestions(id: number) {
 	return response as any
 }
 
-export async function fetchPresignedUrl(filename: string) {
+export async function fetchPresignedUrls(files: File[]) {
 	const response = await apiFetch({
 		endpoint: '/presigned-url',
 		method: 'POST',
-		body: { file_name: filename },
+		body: {
+			files: files.map((file) => ({ file_name: file.name, size: file.size })),
+		},
 	})
 
-	return response as any
+	return response.files as any[]
 }
 
 export async function fetchTags() {
@@ -126,3 +128,13 @@ export async function fetchTags() {
 export async function fetchSubmitJoinRequest() {
 	await apiFetch({ endpoint: '/community/join', method: 'POST' })
 }
+
+export async function fetchConfirmUpload(filename: string) {
+	const response = await apiFetch({
+		endpoint: '/photos/confirm',
+		method: 'POST',
+		body: { file_name: filename },
+	})
+
+	return response as any
+}
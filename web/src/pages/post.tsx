@@ -12,9 +12,10 @@ import {
 import { useMainButton } from '~/lib/useMainButton'
 import { IconClose, IconMap, IconSparkles } from '~/components/icons'
 import {
+	fetchConfirmUpload,
 	fetchCreatePost,
 	fetchPostAISuggestions,
-	fetchPresignedUrl,
+	fetchPresignedUrls,
 	fetchUpdatePost,
 } from '~/lib/api'
 import { useNavigate } from '@solidjs/router'
@@ -27,13 +28,11 @@ type CreatePost = {
 	photo: string
 }
 
-async function uploadToS3(url: string, file: File) {
+async function uploadToS3(url: string, headers: Record<string, string>, file: File) {
 	const response = await fetch(url, {
 		method: 'PUT',
 		body: file,
-		headers: {
-			'Content-Type': file.type,
-		},
+		headers,
 	})
 	if (!response.ok) {
 		throw new Error('Failed to upload image to S3')
@@ -61,9 +60,10 @@ export default function PostPage() {
 		if (imgFile() && imgFile() !== null) {
 			mainButton.showProgress(false)
 			try {
-				const { file_name, url } = await fetchPresignedUrl(imgFile()!.name)
-				await uploadToS3(url, imgFile()!)
-				setEditPost('photo', file_name)
+				const [{ file_name, url, headers }] = await fetchPresignedUrls([imgFile()!])
+				await uploadToS3(url, headers, imgFile()!)
+				const upload = await fetchConfirmUpload(file_name)
+				setEditPost('photo', upload.file_name)
 				const resp = await fetchCreatePost(editPost)
 				await queryClient.invalidateQueries({ queryKey: ['posts'] })
 				navigate('/')